@@ -0,0 +1,130 @@
+package content
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/gorilla/mux"
+)
+
+type requestStatsKey struct{}
+
+// requestStats counts the work done for a request, for its access log entry.
+// A nil *requestStats is valid and counts nothing, e.g. for the content unrolled by the worker.
+type requestStats struct {
+	resolvedUUIDs int64
+	backendCalls  int64
+}
+
+func withRequestStats(ctx context.Context, s *requestStats) context.Context {
+	return context.WithValue(ctx, requestStatsKey{}, s)
+}
+
+func requestStatsFrom(ctx context.Context) *requestStats {
+	s, _ := ctx.Value(requestStatsKey{}).(*requestStats)
+	return s
+}
+
+func (s *requestStats) addBackendCall() {
+	if s != nil {
+		atomic.AddInt64(&s.backendCalls, 1)
+	}
+}
+
+func (s *requestStats) setResolvedUUIDs(n int) {
+	if s != nil {
+		atomic.StoreInt64(&s.resolvedUUIDs, int64(n))
+	}
+}
+
+type accessLogEntry struct {
+	tid           string
+	method        string
+	route         string
+	requestURL    string
+	status        int
+	duration      time.Duration
+	requestBytes  int64
+	responseBytes int64
+	resolvedUUIDs int64
+	backendCalls  int64
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// AccessLog is a mux middleware logging every request served, with its route template, status, timing,
+// request and response sizes, and the number of UUIDs resolved and backend calls made for it.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// the handlers read the transaction id from the request, so they log the same one as the access log
+		tid := transactionidutils.GetTransactionIDFromRequest(r)
+		r.Header.Set(transactionidutils.TransactionIDHeader, tid)
+
+		stats := &requestStats{}
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		aw := &accessLogWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r.WithContext(withRequestStats(r.Context(), stats)))
+
+		route := "unmatched"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logger.AccessEvent(accessLogEntry{
+			tid:           tid,
+			method:        r.Method,
+			route:         route,
+			requestURL:    r.RequestURI,
+			status:        status,
+			duration:      time.Since(start),
+			requestBytes:  body.n,
+			responseBytes: aw.n,
+			resolvedUUIDs: atomic.LoadInt64(&stats.resolvedUUIDs),
+			backendCalls:  atomic.LoadInt64(&stats.backendCalls),
+		})
+	})
+}
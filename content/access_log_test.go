@@ -0,0 +1,85 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func captureAccessLog(t *testing.T, r *mux.Router, req *http.Request) map[string]interface{} {
+	var buf bytes.Buffer
+	out := logger.log.Out
+	logger.log.Out, logger.debugLog.Out = &buf, &buf
+	defer func() { logger.log.Out, logger.debugLog.Out = out, out }()
+
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &entry), "The access log should be the last JSON entry")
+	return entry
+}
+
+func TestAccessLog_LogsRequest(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(AccessLog)
+	r.HandleFunc("/content/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		stats := requestStatsFrom(r.Context())
+		stats.addBackendCall()
+		stats.addBackendCall()
+		stats.setResolvedUUIDs(3)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("unrolled"))
+	}).Methods("POST")
+
+	req := httptest.NewRequest(http.MethodPost, "/content/"+imageSetUUID, strings.NewReader(`{"id":"sample"}`))
+	req.Header.Set("X-Request-Id", "tid_sample")
+	entry := captureAccessLog(t, r, req)
+
+	assert.Equal(t, "tid_sample", entry["transaction_id"])
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "/content/{uuid}", entry["route"])
+	assert.Equal(t, float64(http.StatusCreated), entry["status"])
+	assert.Equal(t, float64(len(`{"id":"sample"}`)), entry["request_bytes"])
+	assert.Equal(t, float64(len("unrolled")), entry["response_bytes"])
+	assert.Equal(t, float64(3), entry["resolved_uuids"])
+	assert.Equal(t, float64(2), entry["backend_calls"])
+	assert.Contains(t, entry, "duration_ms")
+}
+
+func TestAccessLog_LogsUnmatchedRequests(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(AccessLog)
+	r.NotFoundHandler = AccessLog(http.NotFoundHandler())
+
+	entry := captureAccessLog(t, r, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	assert.Equal(t, "unmatched", entry["route"])
+	assert.Equal(t, float64(http.StatusNotFound), entry["status"])
+}
+
+func TestGetContent_CountsResolvedUUIDs(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			uc := req.c.clone()
+			uc[mainImage] = Content{id: "http://www.ft.com/thing/" + imageSetUUID, members: []Content{{id: "http://www.ft.com/thing/" + memberUUID}}}
+			return UnrollResult{uc, nil, []UnresolvedReference{{UUID: memberUUID, Path: "mainImage.members[0]", Reason: reasonNotFound}}}
+		},
+	}
+	h := Handler{Service: &cu}
+	r := mux.NewRouter()
+	r.Use(AccessLog)
+	r.HandleFunc("/content", h.GetContent).Methods("POST")
+
+	req := httptest.NewRequest(http.MethodPost, "/content", strings.NewReader(`{"id":"http://www.ft.com/thing/`+otherUUID+`","mainImage":{"id":"http://www.ft.com/thing/`+imageSetUUID+`"}}`))
+	entry := captureAccessLog(t, r, req)
+	assert.Equal(t, float64(1), entry["resolved_uuids"], "Neither the article nor the unresolved references should be counted")
+}
@@ -1,18 +1,58 @@
 package content
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/sirupsen/logrus"
 )
 
+// DebugLogHeader enables the debug logs of the request's transaction when set to true, whatever the log level.
+// It's honoured through Auth.DebugLog.
+const DebugLogHeader = "X-Debug-Log"
+
 type appLogger struct {
 	log *logrus.Logger
+	// debugLog writes the debug logs of the transactions having them enabled
+	debugLog *logrus.Logger
+	sync.RWMutex
+	debugTIDs map[string]int
 }
 
 func NewAppLogger() *appLogger {
 	logrus.SetLevel(logrus.InfoLevel)
 	log := logrus.New()
 	log.Formatter = new(logrus.JSONFormatter)
-	return &appLogger{log: log}
+	debugLog := logrus.New()
+	debugLog.Formatter = log.Formatter
+	debugLog.Out = log.Out
+	debugLog.Level = logrus.DebugLevel
+	return &appLogger{log: log, debugLog: debugLog, debugTIDs: make(map[string]int)}
+}
+
+// SetLogLevel changes the level of the application logs at runtime.
+func SetLogLevel(level logrus.Level) {
+	logrus.SetLevel(level)
+	atomic.StoreUint32((*uint32)(&logger.log.Level), uint32(level))
+}
+
+func GetLogLevel() logrus.Level {
+	return logrus.Level(atomic.LoadUint32((*uint32)(&logger.log.Level)))
+}
+
+// debugTransaction enables the debug logs of the transaction until the returned function is called.
+func (appLogger *appLogger) debugTransaction(tid string) func() {
+	appLogger.Lock()
+	appLogger.debugTIDs[tid]++
+	appLogger.Unlock()
+	return func() {
+		appLogger.Lock()
+		defer appLogger.Unlock()
+		if appLogger.debugTIDs[tid]--; appLogger.debugTIDs[tid] <= 0 {
+			delete(appLogger.debugTIDs, tid)
+		}
+	}
 }
 
 func (appLogger *appLogger) TransactionStartedEvent(requestURL string, transactionID string, uuid string) {
@@ -30,7 +70,7 @@ func (appLogger *appLogger) TransactionFinishedEvent(requestURL string, transact
 		"uuid":           uuid,
 	})
 
-	if statusCode < 300 {
+	if statusCode < 400 {
 		e.Infof("Transaction %s finished with status %d: %s", transactionID, statusCode, message)
 	} else {
 		e.Errorf("Transaction %s finished with status %d: %s", transactionID, statusCode, message)
@@ -49,6 +89,31 @@ func (appLogger *appLogger) Errorf(tid string, format string, args ...interface{
 	appLogger.log.WithFields(logrus.Fields{"tid": tid}).Errorf(format, args...)
 }
 
+func (appLogger *appLogger) Debugf(tid string, uuid string, format string, args ...interface{}) {
+	log := appLogger.log
+	appLogger.RLock()
+	if appLogger.debugTIDs[tid] > 0 {
+		log = appLogger.debugLog
+	}
+	appLogger.RUnlock()
+	log.WithFields(logrus.Fields{"tid": tid, "uuid": uuid}).Debugf(format, args...)
+}
+
 func (appLogger *appLogger) Warnf(tid string, uuid string, format string, args ...interface{}) {
 	appLogger.log.WithFields(logrus.Fields{"tid": tid, "uuid": uuid}).Warnf(format, args...)
 }
+
+func (appLogger *appLogger) AccessEvent(e accessLogEntry) {
+	appLogger.log.WithFields(logrus.Fields{
+		"transaction_id": e.tid,
+		"method":         e.method,
+		"route":          e.route,
+		"request_url":    e.requestURL,
+		"status":         e.status,
+		"duration_ms":    float64(e.duration) / float64(time.Millisecond),
+		"request_bytes":  e.requestBytes,
+		"response_bytes": e.responseBytes,
+		"resolved_uuids": e.resolvedUUIDs,
+		"backend_calls":  e.backendCalls,
+	}).Infof("%s %s finished with status %d", e.method, e.route, e.status)
+}
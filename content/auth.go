@@ -0,0 +1,195 @@
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/pkg/errors"
+)
+
+// The permissions granted to the callers, checked by the endpoints. The preview endpoints need the same ones.
+// PermissionAdmin allows changing the log level and enabling the debug logs of a transaction.
+const (
+	PermissionContent         = "content"
+	PermissionInternalContent = "internalcontent"
+	PermissionAdmin           = "admin"
+)
+
+// errNoCredentials is returned by an Authenticator when the request has no credentials it handles.
+var errNoCredentials = errors.New("No credentials")
+
+// Authenticator identifies the caller of a request from its credentials.
+type Authenticator interface {
+	// Authenticate returns the caller and its permissions, errNoCredentials when the request has no credentials
+	// for this authenticator, or the reason the credentials are invalid.
+	Authenticate(r *http.Request) (string, []string, error)
+}
+
+// Auth rejects the requests without valid credentials, or whose caller lacks the permission of the endpoint.
+// The credentials are checked by the first of the authenticators they're meant for. A nil *Auth is valid and
+// lets every request through.
+type Auth struct {
+	Authenticators []Authenticator
+	// FailureLimiter limits the failed attempts with the same credentials, e.g. a client retrying with an expired
+	// token. No limit when nil.
+	FailureLimiter *RateLimiter
+}
+
+// credentialReader is implemented by the authenticators able to tell the credentials a request presents to them.
+type credentialReader interface {
+	credential(r *http.Request) string
+}
+
+// Require is a middleware answering 401 to the requests without valid credentials and 403 to the ones whose caller
+// doesn't have the permission. The invalid credentials over the failed attempts limit are answered 429 with
+// Retry-After. The caller is passed on in the context of the request.
+func (a *Auth) Require(permission string, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tid := transactionidutils.GetTransactionIDFromRequest(r)
+
+		caller, permissions, err := a.authenticate(r)
+		if err != nil {
+			// the failed attempts are limited by the credentials presented rather than by IP, as behind the ingress
+			// all the clients have the same IP. Only failed attempts are counted, so valid credentials always go through.
+			if key, found := a.credentialKey(r); found {
+				if wait, allowed := a.FailureLimiter.takeAuthFailure(key); !allowed {
+					logger.Warnf(tid, "", "Too many failed authentication attempts with the same credentials, retry in %v", wait)
+					tooManyRequests(w, wait, "Too many failed authentication attempts")
+					return
+				}
+			}
+			logger.Warnf(tid, "", "Unauthenticated request to %v: %v", r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="content-unroller"`)
+			writeErrorMessage(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !hasPermission(permissions, permission) {
+			logger.Warnf(tid, "", "%v isn't allowed to call %v", caller, r.URL.Path)
+			writeErrorMessage(w, "Missing permission "+permission, http.StatusForbidden)
+			return
+		}
+		logger.Debugf(tid, "", "Request to %v authenticated as %v", r.URL.Path, caller)
+		next.ServeHTTP(w, r.WithContext(withCaller(r.Context(), caller)))
+	})
+}
+
+// DebugLog is a mux middleware enabling the debug logs of the transaction of the requests sending DebugLogHeader, when
+// their caller has the admin permission. Otherwise anyone could enable the debug logs of another transaction by sending
+// its transaction id. It goes after AccessLog, which sets the transaction id of the request.
+func (a *Auth) DebugLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(DebugLogHeader) == "true" {
+			tid := transactionidutils.GetTransactionIDFromRequest(r)
+			if a.isAdmin(r) {
+				defer logger.debugTransaction(tid)()
+			} else {
+				logger.Warnf(tid, "", "Ignoring %v sent without the %v permission", DebugLogHeader, PermissionAdmin)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Auth) isAdmin(r *http.Request) bool {
+	if a == nil {
+		return true
+	}
+	_, permissions, err := a.authenticate(r)
+	return err == nil && hasPermission(permissions, PermissionAdmin)
+}
+
+func (a *Auth) authenticate(r *http.Request) (string, []string, error) {
+	for _, authenticator := range a.Authenticators {
+		caller, permissions, err := authenticator.Authenticate(r)
+		if err != errNoCredentials {
+			return caller, permissions, err
+		}
+	}
+	return "", nil, errNoCredentials
+}
+
+// credentialKey returns the hash of the credentials presented to the first authenticator they're meant for.
+func (a *Auth) credentialKey(r *http.Request) (string, bool) {
+	for _, authenticator := range a.Authenticators {
+		cr, ok := authenticator.(credentialReader)
+		if !ok {
+			continue
+		}
+		if c := cr.credential(r); c != "" {
+			h := sha256.Sum256([]byte(c))
+			return hex.EncodeToString(h[:]), true
+		}
+	}
+	return "", false
+}
+
+func hasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type apiKey struct {
+	Name        string   `json:"name"`
+	Key         string   `json:"key"`
+	Permissions []string `json:"permissions"`
+}
+
+type apiKeysFile struct {
+	Keys []apiKey `json:"keys"`
+}
+
+// APIKeyAuthenticator authenticates the requests by the static API key in a header.
+type APIKeyAuthenticator struct {
+	header string
+	// keys are indexed by the hash of the key, so looking them up doesn't leak the keys through timing
+	keys map[[sha256.Size]byte]apiKey
+}
+
+// NewAPIKeyAuthenticator reads the API keys from a file like
+// {"keys": [{"name": "next", "key": "secret", "permissions": ["content"]}]}.
+func NewAPIKeyAuthenticator(path string, header string) (*APIKeyAuthenticator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read API keys from %v", path)
+	}
+	var f apiKeysFile
+	if err = json.Unmarshal(b, &f); err != nil {
+		return nil, errors.Wrapf(err, "Cannot parse API keys from %v", path)
+	}
+
+	ka := &APIKeyAuthenticator{header: header, keys: make(map[[sha256.Size]byte]apiKey)}
+	for _, k := range f.Keys {
+		if k.Name == "" || k.Key == "" {
+			return nil, errors.Errorf("Every API key in %v needs a name and a key", path)
+		}
+		ka.keys[sha256.Sum256([]byte(k.Key))] = k
+	}
+	return ka, nil
+}
+
+func (ka *APIKeyAuthenticator) credential(r *http.Request) string {
+	return r.Header.Get(ka.header)
+}
+
+func (ka *APIKeyAuthenticator) Authenticate(r *http.Request) (string, []string, error) {
+	key := ka.credential(r)
+	if key == "" {
+		return "", nil, errNoCredentials
+	}
+	k, found := ka.keys[sha256.Sum256([]byte(key))]
+	if !found {
+		return "", nil, errors.New("Invalid API key")
+	}
+	return "API key " + k.Name, k.Permissions, nil
+}
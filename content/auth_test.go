@@ -0,0 +1,175 @@
+package content
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKeyID = "test-key"
+
+func writeTestFile(t *testing.T, name string, v interface{}) (string, func()) {
+	dir, err := ioutil.TempDir("", "auth")
+	assert.NoError(t, err)
+	b, err := json.Marshal(v)
+	assert.NoError(t, err)
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, b, 0644))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		assert.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}) + "." + enc(claims)
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	assert.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestJWTAuthenticator(t *testing.T) (*JWTAuthenticator, *rsa.PrivateKey, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	set := jwks{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: testKeyID,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	path, cleanup := writeTestFile(t, "jwks.json", set)
+
+	ja, err := NewJWTAuthenticator(JWTAuthenticatorConfig{JWKSFile: path, Issuer: "https://auth.ft.com", Audience: "content-unroller"})
+	assert.NoError(t, err)
+	return ja, key, cleanup
+}
+
+func authRequest(a *Auth, permission string, headers map[string]string) *httptest.ResponseRecorder {
+	h := a.Require(permission, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodPost, "/content", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuth_APIKeys(t *testing.T) {
+	path, cleanup := writeTestFile(t, "keys.json", apiKeysFile{Keys: []apiKey{
+		{Name: "next", Key: "next-secret", Permissions: []string{PermissionContent}},
+		{Name: "spark", Key: "spark-secret", Permissions: []string{PermissionContent, PermissionInternalContent}},
+	}})
+	defer cleanup()
+	ka, err := NewAPIKeyAuthenticator(path, "X-Api-Key")
+	assert.NoError(t, err)
+	a := &Auth{Authenticators: []Authenticator{ka}}
+
+	assert.Equal(t, http.StatusOK, authRequest(a, PermissionContent, map[string]string{"X-Api-Key": "next-secret"}).Code)
+	assert.Equal(t, http.StatusForbidden, authRequest(a, PermissionInternalContent, map[string]string{"X-Api-Key": "next-secret"}).Code)
+	assert.Equal(t, http.StatusOK, authRequest(a, PermissionInternalContent, map[string]string{"X-Api-Key": "spark-secret"}).Code)
+
+	w := authRequest(a, PermissionContent, map[string]string{"X-Api-Key": "guessed"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid API key")
+	w = authRequest(a, PermissionContent, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestAuth_JWT(t *testing.T) {
+	ja, key, cleanup := newTestJWTAuthenticator(t)
+	defer cleanup()
+	a := &Auth{Authenticators: []Authenticator{ja}}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "next", "iss": "https://auth.ft.com", "aud": []string{"content-unroller"}, "exp": exp, "permissions": []string{PermissionContent}}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	valid := signToken(t, key, testKeyID, claims(nil))
+	assert.Equal(t, http.StatusOK, authRequest(a, PermissionContent, bearer(valid)).Code)
+	assert.Equal(t, http.StatusForbidden, authRequest(a, PermissionInternalContent, bearer(valid)).Code)
+	scoped := signToken(t, key, testKeyID, claims(map[string]interface{}{"permissions": "content internalcontent"}))
+	assert.Equal(t, http.StatusOK, authRequest(a, PermissionInternalContent, bearer(scoped)).Code)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	invalid := map[string]string{
+		"expired":         signToken(t, key, testKeyID, claims(map[string]interface{}{"exp": float64(time.Now().Add(-time.Hour).Unix())})),
+		"without expiry":  signToken(t, key, testKeyID, claims(map[string]interface{}{"exp": nil})),
+		"other issuer":    signToken(t, key, testKeyID, claims(map[string]interface{}{"iss": "https://evil.com"})),
+		"other audience":  signToken(t, key, testKeyID, claims(map[string]interface{}{"aud": "other-service"})),
+		"unknown key":     signToken(t, key, "other-key", claims(nil)),
+		"other signature": signToken(t, otherKey, testKeyID, claims(nil)),
+		"malformed":       "not-a-token",
+	}
+	for name, token := range invalid {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, http.StatusUnauthorized, authRequest(a, PermissionContent, bearer(token)).Code)
+		})
+	}
+}
+
+func TestAuth_NilLetsEverythingThrough(t *testing.T) {
+	var a *Auth
+	assert.Equal(t, http.StatusOK, authRequest(a, PermissionInternalContent, nil).Code)
+}
+
+func TestNewJWTAuthenticator_RejectsSetsWithoutRSAKeys(t *testing.T) {
+	path, cleanup := writeTestFile(t, "jwks.json", jwks{Keys: []jwk{{Kty: "EC", Kid: "ec-key"}}})
+	defer cleanup()
+
+	_, err := NewJWTAuthenticator(JWTAuthenticatorConfig{JWKSFile: path})
+	assert.Error(t, err)
+}
+
+func TestAuth_LimitsFailedAttempts(t *testing.T) {
+	path, cleanup := writeTestFile(t, "keys.json", apiKeysFile{Keys: []apiKey{{Name: "next", Key: "next-secret", Permissions: []string{PermissionContent}}}})
+	defer cleanup()
+	ka, err := NewAPIKeyAuthenticator(path, "X-Api-Key")
+	assert.NoError(t, err)
+	now := time.Now()
+	rl := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 2})
+	rl.now = func() time.Time { return now }
+	a := &Auth{Authenticators: []Authenticator{ka}, FailureLimiter: rl}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusUnauthorized, authRequest(a, PermissionContent, map[string]string{"X-Api-Key": "guessed"}).Code)
+	}
+	w := authRequest(a, PermissionContent, map[string]string{"X-Api-Key": "guessed"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "Credentials that ran out of attempts should be refused")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), rl.Metrics().AuthFailuresLimited)
+
+	assert.Equal(t, http.StatusOK, authRequest(a, PermissionContent, map[string]string{"X-Api-Key": "next-secret"}).Code,
+		"Valid credentials should never be refused")
+	assert.Equal(t, http.StatusUnauthorized, authRequest(a, PermissionContent, map[string]string{"X-Api-Key": "other"}).Code,
+		"Other credentials should have their own attempts")
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusUnauthorized, authRequest(a, PermissionContent, map[string]string{"X-Api-Key": "guessed"}).Code)
+}
@@ -0,0 +1,234 @@
+package content
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cachedResponse struct {
+	// uuid is the one of the unrolled article
+	uuid       string
+	body       []byte
+	etag       string
+	unresolved []UnresolvedReference
+	resolved   int
+	uuids      map[string]bool
+	expires    time.Time
+}
+
+// ResponseCache keeps unrolled responses for a short period of time, keyed by the hash of the input article.
+// A nil *ResponseCache is valid and caches nothing.
+type ResponseCache struct {
+	sync.RWMutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]cachedResponse
+	// generation changes on every invalidation, so that the responses unrolled before it aren't cached afterwards
+	generation uint64
+	now        func() time.Time
+}
+
+func NewResponseCache(ttl time.Duration, maxSize int) *ResponseCache {
+	return &ResponseCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]cachedResponse),
+		now:     time.Now,
+	}
+}
+
+func (rc *ResponseCache) get(key string) (cachedResponse, bool) {
+	if rc == nil {
+		return cachedResponse{}, false
+	}
+	rc.RLock()
+	defer rc.RUnlock()
+
+	e, found := rc.entries[key]
+	if !found || rc.now().After(e.expires) {
+		return cachedResponse{}, false
+	}
+	return e, true
+}
+
+// currentGeneration returns the generation to pass to set for the response about to be unrolled.
+func (rc *ResponseCache) currentGeneration() uint64 {
+	if rc == nil {
+		return 0
+	}
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.generation
+}
+
+// set caches the response, remembering the UUIDs it references so it can be invalidated when any of them changes.
+// The response isn't cached when an invalidation happened since gen was taken, as it may be stale.
+func (rc *ResponseCache) set(key string, res cachedResponse, uuids []string, gen uint64) {
+	if rc == nil {
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.generation != gen {
+		return
+	}
+
+	now := rc.now()
+	if len(rc.entries) >= rc.maxSize {
+		for k, e := range rc.entries {
+			if now.After(e.expires) {
+				delete(rc.entries, k)
+			}
+		}
+		if len(rc.entries) >= rc.maxSize {
+			return
+		}
+	}
+	refs := make(map[string]bool)
+	for _, u := range uuids {
+		refs[u] = true
+	}
+	res.uuids = refs
+	res.expires = now.Add(rc.ttl)
+	rc.entries[key] = res
+}
+
+// Invalidate evicts every cached response referencing any of the given UUIDs, and returns the UUIDs of the articles
+// of the evicted responses.
+func (rc *ResponseCache) Invalidate(uuids []string) []string {
+	if rc == nil {
+		return nil
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	rc.generation++
+
+	evicted := make(map[string]bool)
+	for k, e := range rc.entries {
+		for _, u := range uuids {
+			if e.uuids[u] {
+				delete(rc.entries, k)
+				evicted[e.uuid] = true
+				break
+			}
+		}
+	}
+	return keys(evicted)
+}
+
+// hashArticle returns a stable hash of the supplied article. Marshalling sorts the map keys,
+// so the same article always produces the same hash regardless of the order of its fields.
+func hashArticle(c Content) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.Sum(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// etagFields are the fields of the models expanded into the article whose changes change the ETag: the lastModified
+// of the content, including the related content summaries, and the display data of the concepts, which have none.
+var etagFields = map[string]bool{lastModified: true, prefLabel: true, "type": true}
+
+// computeETag combines the hash of the input article with the versions of every model in the unrolled content.
+func computeETag(articleHash string, uc Content) string {
+	h := sha1.New()
+	h.Write([]byte(articleHash))
+	for _, v := range collectVersions(uc) {
+		h.Write([]byte(v))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+func collectVersions(uc Content) []string {
+	var res []string
+	walkContent(map[string]interface{}(uc), func(key string, value interface{}) {
+		if v, ok := value.(string); ok && etagFields[key] {
+			res = append(res, key+"="+v)
+		}
+	})
+	return res
+}
+
+// collectUUIDs returns the UUIDs of the article and of every model expanded into it.
+func collectUUIDs(uc Content) []string {
+	found := make(map[string]bool)
+	walkContent(map[string]interface{}(uc), func(key string, value interface{}) {
+		if v, ok := value.(string); ok && key == id {
+			if u, err := extractUUIDFromString(v); err == nil {
+				found[u] = true
+			}
+		}
+	})
+	return keys(found)
+}
+
+// countResolved returns the number of models expanded into the article, out of the UUIDs found in the unrolled content.
+func countResolved(uuids []string, articleUUID string, unresolved []UnresolvedReference) int {
+	placeholders := map[string]bool{articleUUID: true}
+	for _, ref := range unresolved {
+		placeholders[ref.UUID] = true
+	}
+	n := 0
+	for _, u := range uuids {
+		if !placeholders[u] {
+			n++
+		}
+	}
+	return n
+}
+
+// walkContent calls fn for every field of the content tree, visiting map keys in sorted order.
+func walkContent(v interface{}, fn func(key string, value interface{})) {
+	switch t := v.(type) {
+	case Content:
+		walkContent(map[string]interface{}(t), fn)
+	case map[string]interface{}:
+		fields := make([]string, 0, len(t))
+		for k := range t {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		for _, k := range fields {
+			fn(k, t[k])
+			walkContent(t[k], fn)
+		}
+	case []Content:
+		for _, i := range t {
+			walkContent(i, fn)
+		}
+	case []interface{}:
+		for _, i := range t {
+			walkContent(i, fn)
+		}
+	}
+}
+
+func keys(m map[string]bool) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// etagMatches reports whether the If-None-Match header value matches the given etag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package content
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache_ExpiresEntries(t *testing.T) {
+	now := time.Now()
+	rc := NewResponseCache(time.Minute, 10)
+	rc.now = func() time.Time { return now }
+
+	rc.set("key", cachedResponse{body: []byte("body"), etag: `"etag"`}, nil, 0)
+	cached, found := rc.get("key")
+	assert.True(t, found, "Entry should be cached")
+	assert.Equal(t, "body", string(cached.body))
+	assert.Equal(t, `"etag"`, cached.etag)
+
+	now = now.Add(2 * time.Minute)
+	_, found = rc.get("key")
+	assert.False(t, found, "Entry should have expired")
+}
+
+func TestResponseCache_SkipsNewEntriesWhenFull(t *testing.T) {
+	rc := NewResponseCache(time.Minute, 1)
+
+	rc.set("first", cachedResponse{body: []byte("first"), etag: `"1"`}, nil, 0)
+	rc.set("second", cachedResponse{body: []byte("second"), etag: `"2"`}, nil, 0)
+
+	_, found := rc.get("first")
+	assert.True(t, found, "First entry should be cached")
+	_, found = rc.get("second")
+	assert.False(t, found, "Second entry should not be cached when the cache is full")
+}
+
+func TestResponseCache_NilCacheIsNoop(t *testing.T) {
+	var rc *ResponseCache
+	rc.set("key", cachedResponse{body: []byte("body"), etag: `"etag"`}, nil, 0)
+	_, found := rc.get("key")
+	assert.False(t, found)
+}
+
+func TestHashArticle_IsIndependentOfFieldOrder(t *testing.T) {
+	var c1, c2 Content
+	assert.NoError(t, json.Unmarshal([]byte(`{"id":"a","title":"b","lastModified":"c"}`), &c1))
+	assert.NoError(t, json.Unmarshal([]byte(`{"lastModified":"c","title":"b","id":"a"}`), &c2))
+
+	h1, err := hashArticle(c1)
+	assert.NoError(t, err)
+	h2, err := hashArticle(c2)
+	assert.NoError(t, err)
+	assert.Equal(t, h1, h2)
+}
+
+func TestComputeETag_ChangesWhenResolvedModelIsModified(t *testing.T) {
+	uc := Content{
+		"mainImage": Content{lastModified: "2017-03-29T19:39:31.361Z"},
+		"embeds":    []Content{{lastModified: "2017-03-29T18:28:38.571Z"}},
+	}
+	etag := computeETag("hash", uc)
+	assert.Equal(t, etag, computeETag("hash", uc), "ETag should be stable")
+
+	uc["embeds"] = []Content{{lastModified: "2017-04-01T10:00:00.000Z"}}
+	assert.NotEqual(t, etag, computeETag("hash", uc), "ETag should change when a resolved model changes")
+	assert.NotEqual(t, etag, computeETag("other-hash", uc), "ETag should change when the article changes")
+}
+
+func TestComputeETag_ChangesWhenConceptIsModified(t *testing.T) {
+	annotation := func(label string) []interface{} {
+		return []interface{}{map[string]interface{}{id: "http://api.ft.com/things/" + personUUID, "predicate": "about", prefLabel: label}}
+	}
+	uc := Content{"annotations": annotation("Jane Doe")}
+	etag := computeETag("hash", uc)
+
+	uc["annotations"] = annotation("Jane Smith")
+	assert.NotEqual(t, etag, computeETag("hash", uc), "ETag should change when an expanded concept changes")
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"abc"`, `"abc"`))
+	assert.True(t, etagMatches(`"xyz", W/"abc"`, `"abc"`))
+	assert.True(t, etagMatches(`*`, `"abc"`))
+	assert.False(t, etagMatches(`"xyz"`, `"abc"`))
+	assert.False(t, etagMatches("", `"abc"`))
+}
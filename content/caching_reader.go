@@ -0,0 +1,153 @@
+package content
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type cachedContent struct {
+	c       Content
+	expires time.Time
+}
+
+// CachingReader is a Reader keeping the models read from the underlying reader for a configurable period of time.
+type CachingReader struct {
+	sync.RWMutex
+	reader   Reader
+	ttl      time.Duration
+	maxSize  int
+	content  map[string]cachedContent
+	internal map[string]cachedContent
+	// generation changes on every invalidation, so that the models read before it aren't cached afterwards
+	generation uint64
+	now        func() time.Time
+}
+
+func NewCachingReader(r Reader, ttl time.Duration, maxSize int) *CachingReader {
+	return &CachingReader{
+		reader:   r,
+		ttl:      ttl,
+		maxSize:  maxSize,
+		content:  make(map[string]cachedContent),
+		internal: make(map[string]cachedContent),
+		now:      time.Now,
+	}
+}
+
+func (cr *CachingReader) Get(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	return cr.read(ctx, uuids, tid, cr.content, cr.reader.Get)
+}
+
+func (cr *CachingReader) GetInternal(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	return cr.read(ctx, uuids, tid, cr.internal, cr.reader.GetInternal)
+}
+
+func (cr *CachingReader) read(ctx context.Context, uuids []string, tid string, cache map[string]cachedContent, getFn ReaderFunc) (map[string]Content, error) {
+	cm := make(map[string]Content)
+	var missing []string
+
+	cr.RLock()
+	gen := cr.generation
+	for _, uuid := range uuids {
+		c, found := cr.lookup(cache, uuid)
+		if !found {
+			missing = append(missing, uuid)
+			continue
+		}
+
+		// an image set is only usable from the cache together with the models of all its members
+		hit := map[string]Content{uuid: c}
+		for _, mUUID := range c.getMembersUUID() {
+			m, found := cr.lookup(cache, mUUID)
+			if !found {
+				hit = nil
+				break
+			}
+			hit[mUUID] = m
+		}
+		if hit == nil {
+			missing = append(missing, uuid)
+			continue
+		}
+		for k, v := range hit {
+			cm[k] = v.clone()
+		}
+	}
+	cr.RUnlock()
+
+	if len(missing) == 0 {
+		return cm, nil
+	}
+
+	res, err := getFn(ctx, missing, tid)
+	if err != nil {
+		return cm, err
+	}
+
+	cr.Lock()
+	for k, v := range res {
+		// the models may have been invalidated while they were read, in which case they may be stale
+		if cr.generation == gen {
+			cr.store(cache, k, v)
+		}
+		cm[k] = v
+	}
+	cr.Unlock()
+	return cm, nil
+}
+
+func (cr *CachingReader) lookup(cache map[string]cachedContent, uuid string) (Content, bool) {
+	e, found := cache[uuid]
+	if !found || cr.now().After(e.expires) {
+		return nil, false
+	}
+	return e.c, true
+}
+
+func (cr *CachingReader) store(cache map[string]cachedContent, uuid string, c Content) {
+	now := cr.now()
+	if len(cache) >= cr.maxSize {
+		for k, e := range cache {
+			if now.After(e.expires) {
+				delete(cache, k)
+			}
+		}
+		if len(cache) >= cr.maxSize {
+			return
+		}
+	}
+	// the unroller modifies the models it receives, so the cache keeps its own copy
+	cache[uuid] = cachedContent{c: c.clone(), expires: now.Add(cr.ttl)}
+}
+
+// Invalidate evicts the given models, together with every image set referencing any of them as a member.
+func (cr *CachingReader) Invalidate(uuids []string) []string {
+	cr.Lock()
+	defer cr.Unlock()
+	cr.generation++
+
+	evict := make(map[string]bool)
+	for _, u := range uuids {
+		evict[u] = true
+	}
+
+	evicted := make(map[string]bool)
+	for _, cache := range []map[string]cachedContent{cr.content, cr.internal} {
+		for k, e := range cache {
+			if evict[k] {
+				delete(cache, k)
+				evicted[k] = true
+				continue
+			}
+			for _, mUUID := range e.c.getMembersUUID() {
+				if evict[mUUID] {
+					delete(cache, k)
+					evicted[k] = true
+					break
+				}
+			}
+		}
+	}
+	return keys(evicted)
+}
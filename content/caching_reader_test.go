@@ -0,0 +1,136 @@
+package content
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	parentUUID   = "1888b166-13b9-11e7-80f4-13e067d5072c"
+	imageSetUUID = "639cd952-149f-11e7-2ea7-a07ecd9ac73f"
+	memberUUID   = "639cd952-149f-11e7-b0c1-37e417ee6c76"
+	otherUUID    = "71231d3a-13c7-11e7-2ea7-a07ecd9ac73f"
+	missingUUID  = "da0e3d5d-ccf0-3b40-b865-f648189fb849"
+)
+
+func imageSetModels() map[string]Content {
+	return map[string]Content{
+		imageSetUUID: {
+			id:      "http://www.ft.com/thing/" + imageSetUUID,
+			"type":  ImageSetType,
+			members: []interface{}{map[string]interface{}{id: "http://www.ft.com/thing/" + memberUUID}},
+		},
+		memberUUID: {id: "http://www.ft.com/thing/" + memberUUID},
+		otherUUID:  {id: "http://www.ft.com/thing/" + otherUUID},
+	}
+}
+
+func countingReader(calls *[][]string) *ReaderMock {
+	return &ReaderMock{
+		mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			*calls = append(*calls, uuids)
+			models := imageSetModels()
+			res := make(map[string]Content)
+			for _, u := range uuids {
+				if c, found := models[u]; found {
+					res[u] = c
+					if u == imageSetUUID {
+						res[memberUUID] = models[memberUUID]
+					}
+				}
+			}
+			return res, nil
+		},
+	}
+}
+
+func TestCachingReader_ServesCachedModels(t *testing.T) {
+	var calls [][]string
+	cr := NewCachingReader(countingReader(&calls), time.Minute, 100)
+
+	first, err := cr.Get(context.Background(), []string{imageSetUUID}, "tid_1")
+	assert.NoError(t, err)
+	second, err := cr.Get(context.Background(), []string{imageSetUUID, otherUUID}, "tid_2")
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][]string{{imageSetUUID}, {otherUUID}}, calls, "Only missing models should be requested")
+	assert.Len(t, first, 2)
+	assert.Len(t, second, 3)
+	assert.Equal(t, first[imageSetUUID], second[imageSetUUID])
+}
+
+func TestCachingReader_ReturnsCopiesOfCachedModels(t *testing.T) {
+	var calls [][]string
+	cr := NewCachingReader(countingReader(&calls), time.Minute, 100)
+
+	first, err := cr.Get(context.Background(), []string{imageSetUUID}, "tid_1")
+	assert.NoError(t, err)
+	first[imageSetUUID][members] = []Content{}
+
+	second, err := cr.Get(context.Background(), []string{imageSetUUID}, "tid_2")
+	assert.NoError(t, err)
+	assert.IsType(t, []interface{}{}, second[imageSetUUID][members], "Cached model should not be modified by callers")
+}
+
+func TestCachingReader_ExpiresModels(t *testing.T) {
+	var calls [][]string
+	now := time.Now()
+	cr := NewCachingReader(countingReader(&calls), time.Minute, 100)
+	cr.now = func() time.Time { return now }
+
+	_, err := cr.Get(context.Background(), []string{otherUUID}, "tid_1")
+	assert.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = cr.Get(context.Background(), []string{otherUUID}, "tid_2")
+	assert.NoError(t, err)
+
+	assert.Len(t, calls, 2)
+}
+
+func TestCachingReader_DoesNotCacheErrors(t *testing.T) {
+	rm := &ReaderMock{
+		mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			return nil, errors.New("Cannot read content")
+		},
+	}
+	cr := NewCachingReader(rm, time.Minute, 100)
+
+	_, err := cr.Get(context.Background(), []string{otherUUID}, "tid_1")
+	assert.Error(t, err)
+	assert.Empty(t, cr.content)
+}
+
+func TestCachingReader_InvalidateEvictsImageSetsReferencingMember(t *testing.T) {
+	var calls [][]string
+	cr := NewCachingReader(countingReader(&calls), time.Minute, 100)
+
+	_, err := cr.Get(context.Background(), []string{imageSetUUID, otherUUID}, "tid_1")
+	assert.NoError(t, err)
+
+	evicted := cr.Invalidate([]string{memberUUID})
+	assert.Equal(t, []string{imageSetUUID, memberUUID}, evicted)
+
+	_, err = cr.Get(context.Background(), []string{imageSetUUID, otherUUID}, "tid_2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{imageSetUUID}, calls[1], "Evicted image set should be requested again")
+}
+
+func TestCachingReader_DoesNotCacheModelsReadWhileInvalidated(t *testing.T) {
+	var cr *CachingReader
+	cr = NewCachingReader(&ReaderMock{
+		mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			// the notification of a change arrives while the previous version is being read
+			cr.Invalidate([]string{otherUUID})
+			return map[string]Content{otherUUID: imageSetModels()[otherUUID]}, nil
+		},
+	}, time.Minute, 100)
+
+	res, err := cr.Get(context.Background(), []string{otherUUID}, "tid_1")
+	assert.NoError(t, err)
+	assert.Contains(t, res, otherUUID)
+	assert.Empty(t, cr.content, "Models read before an invalidation shouldn't be cached")
+}
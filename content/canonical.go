@@ -0,0 +1,106 @@
+package content
+
+import "strings"
+
+func (u *ContentUnroller) createID(handlerPath string, uuid string) string {
+	scheme := u.apiScheme
+	if scheme == "" {
+		scheme = defaultAPIScheme
+	}
+	return createID(scheme, u.apiHost, handlerPath, uuid)
+}
+
+// canonicalID rewrites an id or API URL to the apiHost based form, keeping whether it points to content
+// or internal content. Values without a UUID are returned unchanged.
+func (u *ContentUnroller) canonicalID(raw string) string {
+	uuid, err := extractUUIDFromString(raw)
+	if err != nil {
+		return raw
+	}
+	if strings.Contains(raw, "/"+internalContentEndpoint+"/") {
+		return u.createID(internalContentEndpoint, uuid)
+	}
+	return u.createID(contentEndpoint, uuid)
+}
+
+// canonicaliseExpanded rewrites the ids of the models expanded into the unrolled content. The parts of the
+// supplied article that weren't expanded are still plain maps rather than Content, and are left untouched.
+func (u *ContentUnroller) canonicaliseExpanded(cc Content) {
+	if mi, ok := cc[mainImage].(Content); ok {
+		u.canonicalise(mi)
+	}
+	if em, ok := cc[embeds].([]Content); ok {
+		for _, c := range em {
+			u.canonicalise(c)
+		}
+	}
+	if altImg, ok := cc[altImages].(map[string]interface{}); ok {
+		for _, ai := range altImg {
+			if c, ok := ai.(Content); ok {
+				u.canonicalise(c)
+			}
+		}
+	}
+	if sp, ok := cc[storyPackage].(Content); ok {
+		u.canonicaliseSummary(sp)
+	}
+	for _, f := range []string{containedIn, contains} {
+		list, _ := cc[f].([]interface{})
+		for _, item := range list {
+			if c, ok := item.(Content); ok {
+				u.canonicaliseSummary(c)
+			}
+		}
+	}
+	if li, ok := cc[leadImages].([]Content); ok {
+		for _, c := range li {
+			if img, ok := c[image].(Content); ok {
+				u.canonicalise(img)
+			}
+		}
+	}
+}
+
+func (u *ContentUnroller) canonicaliseSummary(s Content) {
+	u.canonicalise(s)
+	if mi, ok := s[mainImage].(Content); ok {
+		u.canonicalise(mi)
+	}
+}
+
+// canonicalise rewrites the id and apiUrl of the model and the ids of its members.
+func (u *ContentUnroller) canonicalise(c Content) {
+	if c == nil {
+		return
+	}
+	for _, f := range []string{id, apiURL} {
+		if v, ok := c[f].(string); ok {
+			c[f] = u.canonicalID(v)
+		}
+	}
+
+	// members may still be shared with the reader, so they are copied rather than modified
+	switch ms := c[members].(type) {
+	case []Content:
+		canonical := make([]Content, 0, len(ms))
+		for _, m := range ms {
+			cm := m.clone()
+			u.canonicalise(cm)
+			canonical = append(canonical, cm)
+		}
+		c[members] = canonical
+	case []interface{}:
+		canonical := make([]interface{}, 0, len(ms))
+		for _, m := range ms {
+			mm, ok := m.(map[string]interface{})
+			if !ok {
+				canonical = append(canonical, m)
+				continue
+			}
+			cm := fromMap(mm)
+			u.canonicalise(cm)
+			canonical = append(canonical, map[string]interface{}(cm))
+		}
+		c[members] = canonical
+	}
+}
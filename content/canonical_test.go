@@ -0,0 +1,68 @@
+package content
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnrollContent_CanonicalisesExpandedIDs(t *testing.T) {
+	models := imageSetModels()
+	models[imageSetUUID][apiURL] = "http://api.ft.com/content/" + imageSetUUID
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				return map[string]Content{imageSetUUID: models[imageSetUUID].clone(), memberUUID: models[memberUUID]}, nil
+			},
+		},
+		apiHost:      "api.ft.com",
+		apiScheme:    "https",
+		canonicalIDs: true,
+	}
+
+	article := Content{
+		id:        "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		mainImage: map[string]interface{}{id: "http://api.ft.com/content/" + imageSetUUID},
+		bodyXML: `<body><ft-content type="http://www.ft.com/ontology/content/ImageSet" url="http://api.ft.com/content/` +
+			otherUUID + `" data-embedded="true"></ft-content></body>`,
+	}
+	res := cu.UnrollContent(context.Background(), UnrollEvent{article, "tid_sample", "22c0d426-1466-11e7-b0c1-37e417ee6c76", nil})
+	assert.NoError(t, res.err)
+
+	mi := res.uc[mainImage].(Content)
+	assert.Equal(t, "https://api.ft.com/content/"+imageSetUUID, mi[id])
+	assert.Equal(t, "https://api.ft.com/content/"+imageSetUUID, mi[apiURL])
+	assert.Equal(t, "https://api.ft.com/content/"+memberUUID, mi[members].([]Content)[0][id])
+	assert.Equal(t, "https://api.ft.com/content/"+otherUUID, res.uc[embeds].([]Content)[0][id], "Placeholders should use the configured scheme")
+	assert.Equal(t, "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76", res.uc[id], "The article itself should not be changed")
+	assert.Equal(t, "http://www.ft.com/thing/"+memberUUID, models[memberUUID][id], "Models returned by the reader should not be changed")
+}
+
+func TestUnrollInternalContent_CanonicalisesLeadImages(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				return map[string]Content{memberUUID: {id: "http://www.ft.com/thing/" + memberUUID}}, nil
+			},
+		},
+		apiHost:      "api.ft.com",
+		canonicalIDs: true,
+	}
+
+	article := Content{
+		id:         "http://www.ft.com/thing/5010e2e4-09bd-11e7-97d1-5e720a26771b",
+		leadImages: []interface{}{map[string]interface{}{id: "http://api.ft.com/content/" + memberUUID}},
+	}
+	res := cu.UnrollInternalContent(context.Background(), UnrollEvent{article, "tid_sample", "5010e2e4-09bd-11e7-97d1-5e720a26771b", nil})
+	assert.NoError(t, res.err)
+
+	li := res.uc[leadImages].([]Content)[0]
+	assert.Equal(t, "http://api.ft.com/content/"+memberUUID, li[image].(Content)[id])
+}
+
+func TestCanonicalID_KeepsInternalContentURLs(t *testing.T) {
+	cu := ContentUnroller{apiHost: "api.ft.com"}
+	assert.Equal(t, "http://api.ft.com/internalcontent/"+otherUUID, cu.canonicalID("http://internal.ft.com/internalcontent/"+otherUUID))
+	assert.Equal(t, "not-a-uuid", cu.canonicalID("not-a-uuid"))
+}
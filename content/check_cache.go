@@ -0,0 +1,126 @@
+package content
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/service-status-go/gtg"
+	"github.com/pkg/errors"
+)
+
+// CheckCache runs the healthchecks and the gtg check in the background, so that /__health and /__gtg serve their
+// last results instead of calling the backends on every probe. The checks have to be added before Start is called.
+type CheckCache struct {
+	interval   time.Duration
+	staleAfter time.Duration
+	checks     []*cachedCheck
+	stop       chan struct{}
+	once       sync.Once
+}
+
+// NewCheckCache returns a CheckCache refreshing the results every interval. A result older than staleAfter,
+// e.g. because its check hangs, is reported as a failure.
+func NewCheckCache(interval time.Duration, staleAfter time.Duration) *CheckCache {
+	return &CheckCache{
+		interval:   interval,
+		staleAfter: staleAfter,
+		stop:       make(chan struct{}),
+	}
+}
+
+type cachedCheck struct {
+	sync.RWMutex
+	check      func() (string, error)
+	staleAfter time.Duration
+	output     string
+	err        error
+	checkedAt  time.Time
+	running    int32
+}
+
+// refresh runs the check unless its previous run is still going on, so a hanging check doesn't pile up calls.
+func (c *cachedCheck) refresh() {
+	if !atomic.CompareAndSwapInt32(&c.running, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.running, 0)
+
+	output, err := c.check()
+	c.Lock()
+	defer c.Unlock()
+	c.output, c.err, c.checkedAt = output, err, time.Now()
+}
+
+// result returns the last result with its age, failing when there is none yet or when it's stale.
+func (c *cachedCheck) result() (string, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if c.checkedAt.IsZero() {
+		return "Error", errors.New("Not checked yet")
+	}
+	age := time.Since(c.checkedAt).Round(time.Millisecond)
+	if age > c.staleAfter {
+		return "Error", errors.Errorf("Last result is stale, checked %v ago", age)
+	}
+	if c.err != nil {
+		return c.output, errors.Errorf("%v (checked %v ago)", c.err, age)
+	}
+	return c.output + " (checked " + age.String() + " ago)", nil
+}
+
+func (cc *CheckCache) add(check func() (string, error)) *cachedCheck {
+	c := &cachedCheck{check: check, staleAfter: cc.staleAfter}
+	cc.checks = append(cc.checks, c)
+	return c
+}
+
+// Check returns the healthcheck serving the last result of hc.
+func (cc *CheckCache) Check(hc fthealth.Check) fthealth.Check {
+	hc.Checker = cc.add(hc.Checker).result
+	return hc
+}
+
+// Gtg returns the gtg check serving the last status of checker.
+func (cc *CheckCache) Gtg(checker gtg.StatusChecker) gtg.StatusChecker {
+	c := cc.add(func() (string, error) {
+		if s := checker(); !s.GoodToGo {
+			return "Error", errors.New(s.Message)
+		}
+		return "Ok", nil
+	})
+	return func() gtg.Status {
+		if _, err := c.result(); err != nil {
+			return gtg.Status{GoodToGo: false, Message: err.Error()}
+		}
+		return gtg.Status{GoodToGo: true}
+	}
+}
+
+// Start refreshes the results every interval until Stop is called. The checks run in parallel and independently,
+// so a slow check only delays its own result.
+func (cc *CheckCache) Start() {
+	ticker := time.NewTicker(cc.interval)
+	defer ticker.Stop()
+	for {
+		cc.refresh()
+		select {
+		case <-cc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cc *CheckCache) Stop() {
+	cc.once.Do(func() {
+		close(cc.stop)
+	})
+}
+
+func (cc *CheckCache) refresh() {
+	for _, c := range cc.checks {
+		go c.refresh()
+	}
+}
@@ -0,0 +1,122 @@
+package content
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/service-status-go/gtg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCache_ServesLastResult(t *testing.T) {
+	var calls int32
+	cc := NewCheckCache(time.Hour, time.Hour)
+	check := cc.Check(fthealth.Check{
+		Name: "counting",
+		Checker: func() (string, error) {
+			atomic.AddInt32(&calls, 1)
+			return "Ok", nil
+		},
+	})
+
+	_, err := check.Checker()
+	assert.EqualError(t, err, "Not checked yet")
+
+	go cc.Start()
+	defer cc.Stop()
+	waitFor(t, func() bool {
+		_, err := check.Checker()
+		return err == nil
+	})
+
+	for i := 0; i < 3; i++ {
+		out, err := check.Checker()
+		assert.NoError(t, err)
+		assert.Contains(t, out, "Ok (checked ")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "The checker should only run in the background")
+}
+
+func TestCheckCache_ReportsFailures(t *testing.T) {
+	cc := NewCheckCache(time.Hour, time.Hour)
+	check := cc.Check(fthealth.Check{
+		Checker: func() (string, error) {
+			return "Error", errors.New("content store is unreachable")
+		},
+	})
+	gtgCheck := cc.Gtg(func() gtg.Status {
+		return gtg.Status{GoodToGo: false, Message: "content store is unreachable"}
+	})
+
+	cc.refresh()
+	// the checks are refreshed in parallel
+	waitFor(t, func() bool {
+		_, err := check.Checker()
+		return gtgCheck().Message != "Not checked yet" && err != nil && err.Error() != "Not checked yet"
+	})
+
+	_, err := check.Checker()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "content store is unreachable (checked ")
+	status := gtgCheck()
+	assert.False(t, status.GoodToGo)
+	assert.Contains(t, status.Message, "content store is unreachable")
+}
+
+func TestCheckCache_ReportsStaleResults(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	cc := NewCheckCache(time.Hour, 10*time.Millisecond)
+	gtgCheck := cc.Gtg(func() gtg.Status {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			<-release
+		}
+		return gtg.Status{GoodToGo: true}
+	})
+	defer close(release)
+
+	cc.refresh()
+	waitFor(t, func() bool {
+		return gtgCheck().GoodToGo
+	})
+
+	// the second run hangs, so the first result goes stale
+	cc.refresh()
+	cc.refresh()
+	time.Sleep(20 * time.Millisecond)
+	status := gtgCheck()
+	assert.False(t, status.GoodToGo)
+	assert.Contains(t, status.Message, "stale")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "A hanging check shouldn't be run again")
+}
+
+func TestServiceConfig_CachedGtgCheck_ReportsShutdownStraightAway(t *testing.T) {
+	ts := startFunctionalService()
+	defer ts.Close()
+	sc := initTestServiceConfig(ts.URL)
+	cc := NewCheckCache(time.Hour, time.Hour)
+	gtgCheck := sc.CachedGtgCheck(cc)
+
+	cc.refresh()
+	waitFor(t, func() bool {
+		return gtgCheck().GoodToGo
+	})
+
+	sc.StartDraining()
+	assert.False(t, gtgCheck().GoodToGo)
+	assert.Equal(t, "Shutting down", gtgCheck().Message)
+}
+
+// waitFor fails the test unless cond becomes true within a second.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,165 @@
+package content
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minCompressSize is the size under which the responses aren't worth compressing.
+const minCompressSize = 1024
+
+// maxBodySize is the size over which the decoded request bodies and backend responses are rejected, so that
+// a small compressed body can't exhaust the memory.
+const maxBodySize = 32 << 20
+
+var errBodyTooLarge = requestError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Body larger than %d bytes", maxBodySize)}
+
+// Encoding is a content coding of the request and response bodies, e.g. gzip.
+type Encoding struct {
+	Name      string
+	NewWriter func(w io.Writer) io.WriteCloser
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var gzipEncoding = Encoding{
+	Name:      "gzip",
+	NewWriter: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+	NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+}
+
+// encodings are the supported encodings, the preferred first.
+var encodings = []Encoding{gzipEncoding}
+
+// RegisterEncoding adds an encoding, preferred to the ones already supported, e.g. brotli from a third party package.
+// It must be called before the server starts.
+func RegisterEncoding(e Encoding) {
+	for i, registered := range encodings {
+		if registered.Name == e.Name {
+			encodings = append(encodings[:i], encodings[i+1:]...)
+			break
+		}
+	}
+	encodings = append([]Encoding{e}, encodings...)
+}
+
+func findEncoding(name string) (Encoding, bool) {
+	for _, e := range encodings {
+		if strings.EqualFold(e.Name, name) {
+			return e, true
+		}
+	}
+	return Encoding{}, false
+}
+
+// acceptedEncodings is the Accept-Encoding header of the requests to the backends.
+func acceptedEncodings() string {
+	var names []string
+	for _, e := range encodings {
+		names = append(names, e.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// negotiateEncoding returns the encoding of the response among the ones accepted by the client, with the highest
+// q-value first and the server's preference among equals. It returns false when the response isn't to be encoded.
+func negotiateEncoding(acceptEncoding string) (Encoding, bool) {
+	best, bestQ := Encoding{}, 0.0
+	for _, e := range encodings {
+		if q := encodingQuality(acceptEncoding, e.Name); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// encodingQuality returns the q-value given to the encoding in the Accept-Encoding header, 0 when not accepted.
+func encodingQuality(acceptEncoding string, name string) float64 {
+	q := 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.TrimSpace(fields[0])
+		if !strings.EqualFold(coding, name) && coding != "*" {
+			continue
+		}
+		cq := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					cq = v
+				}
+			}
+		}
+		// the encoding named explicitly takes precedence over the wildcard
+		if strings.EqualFold(coding, name) {
+			return cq
+		}
+		q = cq
+	}
+	return q
+}
+
+// encodeBody compresses the body with the encoding.
+func encodeBody(e Encoding, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := e.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBody reads the body sent with the given Content-Encoding, up to maxBodySize once decoded.
+func decodeBody(contentEncoding string, body io.Reader) ([]byte, error) {
+	contentEncoding = strings.TrimSpace(contentEncoding)
+	if contentEncoding == "" || strings.EqualFold(contentEncoding, "identity") {
+		return readLimited(body)
+	}
+	e, found := findEncoding(contentEncoding)
+	if !found {
+		return nil, requestError{http.StatusUnsupportedMediaType, "Unsupported Content-Encoding " + contentEncoding}
+	}
+	r, err := e.NewReader(body)
+	if err != nil {
+		return nil, requestError{http.StatusBadRequest, fmt.Sprintf("Invalid %v body: %v", e.Name, err)}
+	}
+	defer r.Close()
+	b, err := readLimited(r)
+	if err != nil && err != errBodyTooLarge {
+		return nil, requestError{http.StatusBadRequest, fmt.Sprintf("Invalid %v body: %v", e.Name, err)}
+	}
+	return b, err
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	return b, nil
+}
+
+// encodedETag is the ETag of the response encoded with e, as every encoding of a response is a different representation.
+func encodedETag(etag string, e Encoding) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + e.Name + `"`
+}
+
+// responseEncoding returns the encoding of the body accepted by the client, false when the body is to be sent as is.
+func responseEncoding(r *http.Request, body []byte) (Encoding, bool) {
+	if len(body) < minCompressSize {
+		return Encoding{}, false
+	}
+	return negotiateEncoding(r.Header.Get("Accept-Encoding"))
+}
@@ -0,0 +1,159 @@
+package content
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipped(t *testing.T, b []byte) []byte {
+	encoded, err := encodeBody(gzipEncoding, b)
+	assert.NoError(t, err)
+	return encoded
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]bool{
+		"":                       false,
+		"gzip":                   true,
+		"deflate, gzip;q=0.5":    true,
+		"GZIP":                   true,
+		"gzip;q=0":               false,
+		"*":                      true,
+		"*;q=0.1, gzip;q=0":      false,
+		"identity, deflate, br ": false,
+	}
+	for acceptEncoding, expected := range tests {
+		e, ok := negotiateEncoding(acceptEncoding)
+		assert.Equal(t, expected, ok, acceptEncoding)
+		if expected {
+			assert.Equal(t, "gzip", e.Name, acceptEncoding)
+		}
+	}
+}
+
+func TestGetContent_CompressesResponse(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	req := httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	etag := rr.Header().Get("ETag")
+
+	req = httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+	gzipETag := rr.Header().Get("ETag")
+	assert.NotEqual(t, etag, gzipETag, "Every encoding of the response should have its own ETag")
+
+	zr, err := gzip.NewReader(rr.Body)
+	assert.NoError(t, err)
+	decoded, err := ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(body), string(decoded))
+
+	for _, inm := range []string{etag, gzipETag} {
+		req = httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("If-None-Match", inm)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotModified, rr.Code, "The ETags of all the encodings should be current")
+	}
+}
+
+func TestGetContent_AcceptsCompressedRequest(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	req := httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(gzipped(t, body)))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, string(body), rr.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "compress")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	assert.Equal(t, "Unsupported Content-Encoding compress", rr.Body.String())
+}
+
+func TestGetContent_RejectsCompressedRequestTooLarge(t *testing.T) {
+	h := Handler{Service: &ContentUnrollerMock{}}
+	bomb := gzipped(t, make([]byte, maxBodySize+1))
+
+	req := httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(bomb))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Body larger than")
+}
+
+func TestGet_RequestsCompressedResponse(t *testing.T) {
+	body, err := ioutil.ReadFile("../test-resources/source-content-valid-response.json")
+	assert.NoError(t, err, "Cannot read test file")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped(t, body))
+	}))
+	defer ts.Close()
+
+	expectedBody, err := ioutil.ReadFile("../test-resources/reader-content-valid-response.json")
+	assert.NoError(t, err, "Cannot read test file")
+	var expected map[string]Content
+	assert.NoError(t, json.Unmarshal(expectedBody, &expected))
+
+	actual, err := readerForTest(ts.URL).Get(context.Background(), testData, "tid_1")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestGet_FailsOnUnsupportedResponseEncodingAsBackendError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+
+	_, err := readerForTest(ts.URL).Get(context.Background(), testData, "tid_1")
+	assert.Error(t, err)
+	_, isRequestError := errors.Cause(err).(requestError)
+	assert.False(t, isRequestError, "A backend response failing to decode isn't the fault of the client")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/content", nil)
+	handleError(req, "tid_1", "", rr, err, http.StatusInternalServerError)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
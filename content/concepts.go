@@ -0,0 +1,338 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	uuidutils "github.com/Financial-Times/uuid-utils-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	brands      = "brands"
+	annotations = "annotations"
+	prefLabel   = "prefLabel"
+	directType  = "directType"
+)
+
+// ExpandBrands and ExpandAnnotations replace the concept URIs of the brands and annotations with their display data.
+const (
+	ExpandBrands      = "brands"
+	ExpandAnnotations = "annotations"
+)
+
+// thingsBatchSize is the maximum number of concepts requested from the things API in a single call.
+const thingsBatchSize = 50
+
+// ConceptReader reads concepts, keyed by UUID.
+type ConceptReader interface {
+	GetConcepts(context.Context, []string, string) (map[string]Content, error)
+}
+
+type ThingsReaderConfig struct {
+	ThingsAppName      string
+	ThingsHost         string
+	ThingsPathEndpoint string
+}
+
+// ThingsReader reads concepts from the public things API.
+type ThingsReader struct {
+	metrics ReaderMetrics
+	client  *http.Client
+	config  ThingsReaderConfig
+}
+
+type thingsResponse struct {
+	Things map[string]Content `json:"things"`
+}
+
+func NewThingsReader(config ThingsReaderConfig, client *http.Client) *ThingsReader {
+	return &ThingsReader{
+		client: client,
+		config: config,
+	}
+}
+
+// Metrics returns a snapshot of the reader's lookup counters.
+func (tr *ThingsReader) Metrics() ReaderMetrics {
+	return ReaderMetrics{
+		RequestedUUIDs: atomic.LoadInt64(&tr.metrics.RequestedUUIDs),
+		BackendCalls:   atomic.LoadInt64(&tr.metrics.BackendCalls),
+	}
+}
+
+// GetConcepts reads the concepts in batches of at most thingsBatchSize UUIDs.
+func (tr *ThingsReader) GetConcepts(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	cm := make(map[string]Content)
+	var validUUIDs []string
+	for _, uuid := range uuids {
+		if err := uuidutils.ValidateUUID(uuid); err == nil {
+			validUUIDs = append(validUUIDs, uuid)
+		}
+	}
+	atomic.AddInt64(&tr.metrics.RequestedUUIDs, int64(len(validUUIDs)))
+
+	for start := 0; start < len(validUUIDs); start += thingsBatchSize {
+		end := start + thingsBatchSize
+		if end > len(validUUIDs) {
+			end = len(validUUIDs)
+		}
+		things, err := tr.doGet(ctx, validUUIDs[start:end], tid)
+		if err != nil {
+			return cm, err
+		}
+		for k, v := range things {
+			cm[k] = v
+		}
+	}
+	return cm, nil
+}
+
+func (tr *ThingsReader) doGet(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	appName := tr.config.ThingsAppName
+	reqURL := fmt.Sprintf("%s%s", tr.config.ThingsHost, tr.config.ThingsPathEndpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating request to %v", appName)
+	}
+
+	req.Header.Add(transactionidutils.TransactionIDHeader, tid)
+	req.Header.Set(userAgent, userAgentValue)
+	q := req.URL.Query()
+	for _, uuid := range uuids {
+		q.Add("uuid", uuid)
+	}
+	req.URL.RawQuery = q.Encode()
+	atomic.AddInt64(&tr.metrics.BackendCalls, 1)
+	requestStatsFrom(ctx).addBackendCall()
+	res, err := tr.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Request to %v failed.", appName)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Request to %v failed with status code %d", appName, res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading response received from %v", appName)
+	}
+
+	var things thingsResponse
+	if err = json.Unmarshal(body, &things); err != nil {
+		return nil, errors.Wrapf(err, "Error unmarshalling response from %v", appName)
+	}
+	return things.Things, nil
+}
+
+// CachingConceptReader keeps the concepts read from the underlying reader for a configurable period of time.
+type CachingConceptReader struct {
+	sync.RWMutex
+	reader   ConceptReader
+	ttl      time.Duration
+	maxSize  int
+	concepts map[string]cachedContent
+	// generation changes on every invalidation, so that the concepts read before it aren't cached afterwards
+	generation uint64
+	now        func() time.Time
+}
+
+func NewCachingConceptReader(r ConceptReader, ttl time.Duration, maxSize int) *CachingConceptReader {
+	return &CachingConceptReader{
+		reader:   r,
+		ttl:      ttl,
+		maxSize:  maxSize,
+		concepts: make(map[string]cachedContent),
+		now:      time.Now,
+	}
+}
+
+func (cr *CachingConceptReader) GetConcepts(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	cm := make(map[string]Content)
+	var missing []string
+
+	cr.RLock()
+	gen := cr.generation
+	now := cr.now()
+	for _, uuid := range uuids {
+		e, found := cr.concepts[uuid]
+		if !found || now.After(e.expires) {
+			missing = append(missing, uuid)
+			continue
+		}
+		cm[uuid] = e.c.clone()
+	}
+	cr.RUnlock()
+
+	if len(missing) == 0 {
+		return cm, nil
+	}
+
+	res, err := cr.reader.GetConcepts(ctx, missing, tid)
+	if err != nil {
+		return cm, err
+	}
+
+	cr.Lock()
+	for k, v := range res {
+		if cr.generation == gen {
+			cr.store(k, v)
+		}
+		cm[k] = v
+	}
+	cr.Unlock()
+	return cm, nil
+}
+
+func (cr *CachingConceptReader) store(uuid string, c Content) {
+	now := cr.now()
+	if len(cr.concepts) >= cr.maxSize {
+		for k, e := range cr.concepts {
+			if now.After(e.expires) {
+				delete(cr.concepts, k)
+			}
+		}
+		if len(cr.concepts) >= cr.maxSize {
+			return
+		}
+	}
+	cr.concepts[uuid] = cachedContent{c: c.clone(), expires: now.Add(cr.ttl)}
+}
+
+// Invalidate evicts the given concepts.
+func (cr *CachingConceptReader) Invalidate(uuids []string) []string {
+	cr.Lock()
+	defer cr.Unlock()
+	cr.generation++
+
+	var evicted []string
+	for _, u := range uuids {
+		if _, found := cr.concepts[u]; found {
+			delete(cr.concepts, u)
+			evicted = append(evicted, u)
+		}
+	}
+	return evicted
+}
+
+type conceptRef struct {
+	path  string
+	field string
+	index int
+	uuid  string
+}
+
+// unrollConcepts replaces the brands and annotations of the article with the display data of their concepts.
+// Concepts that can't be resolved are left as they are.
+func (u *ContentUnroller) unrollConcepts(ctx context.Context, cc Content, req UnrollEvent, report *unrollReport) {
+	var fields []string
+	if req.expand[ExpandBrands] {
+		fields = append(fields, brands)
+	}
+	if req.expand[ExpandAnnotations] {
+		fields = append(fields, annotations)
+	}
+	if len(fields) == 0 {
+		return
+	}
+	if u.concepts == nil {
+		logger.Info(req.tid, req.uuid, "No concept reader configured. Skipping expanding concepts")
+		return
+	}
+
+	var refs []conceptRef
+	var uuids []string
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		list, _ := cc[f].([]interface{})
+		for i, item := range list {
+			path := indexedPath(f, i)
+			cID := conceptID(item)
+			cUUID, err := extractUUIDFromString(cID)
+			if err != nil {
+				report.add(cID, path, reasonInvalidUUID)
+				continue
+			}
+			refs = append(refs, conceptRef{path: path, field: f, index: i, uuid: cUUID})
+			if !seen[cUUID] {
+				uuids = append(uuids, cUUID)
+				seen[cUUID] = true
+			}
+		}
+	}
+	if len(refs) == 0 {
+		return
+	}
+
+	concepts, err := u.concepts.GetConcepts(ctx, uuids, req.tid)
+	if err != nil {
+		logger.Errorf(req.tid, "Error while getting concepts %s", err.Error())
+		for _, ref := range refs {
+			report.add(ref.uuid, ref.path, reasonBackendError)
+		}
+		return
+	}
+
+	// the article is only shallow copied, so the lists are copied before being expanded
+	expanded := make(map[string][]interface{})
+	for _, f := range fields {
+		if list, ok := cc[f].([]interface{}); ok {
+			expanded[f] = append([]interface{}{}, list...)
+		}
+	}
+	for _, ref := range refs {
+		concept, found := concepts[ref.uuid]
+		if !found {
+			report.add(ref.uuid, ref.path, reasonNotFound)
+			continue
+		}
+		expanded[ref.field][ref.index] = displayData(expanded[ref.field][ref.index], concept)
+	}
+	for f, list := range expanded {
+		cc[f] = list
+	}
+}
+
+// conceptID returns the id of a concept reference, given either as a plain URI or as an object with an id.
+func conceptID(ref interface{}) string {
+	switch v := ref.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		id, _ := v[id].(string)
+		return id
+	}
+	return ""
+}
+
+// displayData adds the prefLabel, type and apiUrl of the concept to the reference, keeping its other fields.
+func displayData(ref interface{}, concept Content) Content {
+	c := Content{}
+	switch v := ref.(type) {
+	case string:
+		c[id] = v
+	case map[string]interface{}:
+		c = fromMap(v)
+	}
+	if l, found := concept[prefLabel]; found {
+		c[prefLabel] = l
+	}
+	if t, found := concept[directType]; found {
+		c["type"] = t
+	} else if t, found := concept["type"]; found {
+		c["type"] = t
+	}
+	if a, found := concept[apiURL]; found {
+		c[apiURL] = a
+	}
+	return c
+}
@@ -0,0 +1,224 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	brandUUID   = "dbb0bdae-1f0c-11e4-b0cb-b2227cce2b54"
+	personUUID  = "9a5e3b4a-55da-498c-816f-9c534e1392bd"
+	conceptHost = "http://api.ft.com/things/"
+)
+
+type ConceptReaderMock struct {
+	mockGetConcepts func(uuids []string, tid string) (map[string]Content, error)
+}
+
+func (cr *ConceptReaderMock) GetConcepts(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	return cr.mockGetConcepts(uuids, tid)
+}
+
+func conceptModels() map[string]Content {
+	return map[string]Content{
+		brandUUID: {
+			id:          conceptHost + brandUUID,
+			apiURL:      conceptHost + brandUUID,
+			prefLabel:   "Lex",
+			"type":      "http://www.ft.com/ontology/product/Brand",
+			"aliases":   []interface{}{"Lex column"},
+			"broaderId": "http://api.ft.com/things/dbb0bdae-1f0c-11e4-b0cb-b2227cce2b54",
+		},
+		personUUID: {
+			id:         conceptHost + personUUID,
+			apiURL:     conceptHost + personUUID,
+			prefLabel:  "Theresa May",
+			"type":     "http://www.ft.com/ontology/concept/Concept",
+			directType: "http://www.ft.com/ontology/person/Person",
+		},
+	}
+}
+
+func conceptArticle() Content {
+	return Content{
+		id:     "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		brands: []interface{}{"http://api.ft.com/things/" + brandUUID, "http://api.ft.com/things/" + missingUUID},
+		annotations: []interface{}{
+			map[string]interface{}{id: "http://api.ft.com/things/" + personUUID, "predicate": "http://www.ft.com/ontology/annotation/about"},
+			map[string]interface{}{id: "not-a-concept"},
+		},
+	}
+}
+
+func TestUnrollContent_ExpandsBrandsAndAnnotations(t *testing.T) {
+	var calls [][]string
+	cu := ContentUnroller{
+		reader: &ReaderMock{},
+		concepts: &ConceptReaderMock{
+			mockGetConcepts: func(uuids []string, tid string) (map[string]Content, error) {
+				calls = append(calls, uuids)
+				res := make(map[string]Content)
+				for _, u := range uuids {
+					if c, found := conceptModels()[u]; found {
+						res[u] = c
+					}
+				}
+				return res, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := conceptArticle()
+	res := cu.UnrollContent(context.Background(), UnrollEvent{article, "tid_sample", "22c0d426-1466-11e7-b0c1-37e417ee6c76", expansions{ExpandBrands: true, ExpandAnnotations: true}})
+	assert.NoError(t, res.err)
+	assert.Equal(t, [][]string{{brandUUID, missingUUID, personUUID}}, calls, "All concepts should be read in one batch")
+
+	expBrands := res.uc[brands].([]interface{})
+	assert.Equal(t, Content{
+		id:        "http://api.ft.com/things/" + brandUUID,
+		apiURL:    conceptHost + brandUUID,
+		prefLabel: "Lex",
+		"type":    "http://www.ft.com/ontology/product/Brand",
+	}, expBrands[0], "Only the display data of the concept should be added")
+	assert.Equal(t, "http://api.ft.com/things/"+missingUUID, expBrands[1], "Unresolved brands should be left as they are")
+
+	expAnnotations := res.uc[annotations].([]interface{})
+	assert.Equal(t, Content{
+		id:          "http://api.ft.com/things/" + personUUID,
+		"predicate": "http://www.ft.com/ontology/annotation/about",
+		apiURL:      conceptHost + personUUID,
+		prefLabel:   "Theresa May",
+		"type":      "http://www.ft.com/ontology/person/Person",
+	}, expAnnotations[0], "The most specific type of the concept should be used")
+	assert.Equal(t, map[string]interface{}{id: "not-a-concept"}, expAnnotations[1])
+
+	assert.Equal(t, []UnresolvedReference{
+		{UUID: "not-a-concept", Path: "annotations[1]", Reason: reasonInvalidUUID},
+		{UUID: missingUUID, Path: "brands[1]", Reason: reasonNotFound},
+	}, res.unresolved)
+
+	assert.IsType(t, "", article[brands].([]interface{})[0], "The supplied article should not be modified")
+}
+
+func TestUnrollContent_ExpandsOnlyRequestedConcepts(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{},
+		concepts: &ConceptReaderMock{
+			mockGetConcepts: func(uuids []string, tid string) (map[string]Content, error) {
+				assert.Equal(t, []string{brandUUID, missingUUID}, uuids)
+				return conceptModels(), nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := conceptArticle()
+	res := cu.UnrollContent(context.Background(), UnrollEvent{article, "tid_sample", "22c0d426-1466-11e7-b0c1-37e417ee6c76", expansions{ExpandBrands: true}})
+	assert.NoError(t, res.err)
+	assert.IsType(t, Content{}, res.uc[brands].([]interface{})[0])
+	assert.Equal(t, article[annotations], res.uc[annotations])
+}
+
+func TestUnrollContent_ReportsConceptsOnBackendError(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{},
+		concepts: &ConceptReaderMock{
+			mockGetConcepts: func(uuids []string, tid string) (map[string]Content, error) {
+				return nil, errors.New("things API unavailable")
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := conceptArticle()
+	res := cu.UnrollContent(context.Background(), UnrollEvent{article, "tid_sample", "22c0d426-1466-11e7-b0c1-37e417ee6c76", expansions{ExpandBrands: true}})
+	assert.NoError(t, res.err, "Concepts are optional, so the content should still be unrolled")
+	assert.Equal(t, article[brands], res.uc[brands])
+	assert.Equal(t, []UnresolvedReference{
+		{UUID: brandUUID, Path: "brands[0]", Reason: reasonBackendError},
+		{UUID: missingUUID, Path: "brands[1]", Reason: reasonBackendError},
+	}, res.unresolved)
+}
+
+func TestThingsReader_ReadsConceptsInBatches(t *testing.T) {
+	var batches [][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/things", r.URL.Path)
+		assert.Equal(t, "tid_sample", r.Header.Get("X-Request-Id"))
+		uuids := r.URL.Query()["uuid"]
+		batches = append(batches, uuids)
+
+		things := make(map[string]Content)
+		for _, u := range uuids {
+			things[u] = Content{id: conceptHost + u, prefLabel: "Concept " + u}
+		}
+		json.NewEncoder(w).Encode(thingsResponse{Things: things})
+	}))
+	defer ts.Close()
+
+	var uuids []string
+	for i := 0; i < thingsBatchSize+1; i++ {
+		uuids = append(uuids, fmt.Sprintf("00000000-0000-0000-0000-%012d", i))
+	}
+	uuids = append(uuids, "not-a-uuid")
+
+	tr := NewThingsReader(ThingsReaderConfig{ThingsAppName: "public-things-api", ThingsHost: ts.URL, ThingsPathEndpoint: "/things"}, http.DefaultClient)
+	res, err := tr.GetConcepts(context.Background(), uuids, "tid_sample")
+	assert.NoError(t, err)
+	assert.Len(t, res, thingsBatchSize+1)
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], thingsBatchSize)
+	assert.Equal(t, ReaderMetrics{RequestedUUIDs: thingsBatchSize + 1, BackendCalls: 2}, tr.Metrics())
+}
+
+func TestThingsReader_ThingsAppReturns500(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	tr := NewThingsReader(ThingsReaderConfig{ThingsAppName: "public-things-api", ThingsHost: ts.URL, ThingsPathEndpoint: "/things"}, http.DefaultClient)
+	_, err := tr.GetConcepts(context.Background(), []string{brandUUID}, "tid_sample")
+	assert.Error(t, err)
+}
+
+func TestCachingConceptReader_ServesCachedConcepts(t *testing.T) {
+	var calls [][]string
+	cr := NewCachingConceptReader(&ConceptReaderMock{
+		mockGetConcepts: func(uuids []string, tid string) (map[string]Content, error) {
+			calls = append(calls, uuids)
+			res := make(map[string]Content)
+			for _, u := range uuids {
+				res[u] = conceptModels()[u]
+			}
+			return res, nil
+		},
+	}, time.Minute, 10)
+
+	_, err := cr.GetConcepts(context.Background(), []string{brandUUID}, "tid_sample")
+	assert.NoError(t, err)
+	res, err := cr.GetConcepts(context.Background(), []string{brandUUID, personUUID}, "tid_sample")
+	assert.NoError(t, err)
+	assert.Equal(t, "Lex", res[brandUUID][prefLabel])
+	assert.Equal(t, [][]string{{brandUUID}, {personUUID}}, calls)
+
+	assert.Equal(t, []string{brandUUID}, cr.Invalidate([]string{brandUUID}))
+	_, err = cr.GetConcepts(context.Background(), []string{brandUUID}, "tid_sample")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{brandUUID}, {personUUID}, {brandUUID}}, calls)
+
+	later := time.Now().Add(2 * time.Minute)
+	cr.now = func() time.Time { return later }
+	_, err = cr.GetConcepts(context.Background(), []string{personUUID}, "tid_sample")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{brandUUID}, {personUUID}, {brandUUID}, {personUUID}}, calls, "Expired concepts should be read again")
+}
@@ -0,0 +1,127 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// internalContentDir is the subdirectory of a FileReader directory holding the internal content models.
+const internalContentDir = "internalcontent"
+
+// FileReader reads the models from the JSON files of a directory, e.g. responses saved from content-public-read.
+// Every file holds either a single model or a list of models. The models found in the internalcontent subdirectory
+// are only used by GetInternal, which falls back to the other models.
+type FileReader struct {
+	content  map[string]Content
+	internal map[string]Content
+}
+
+func NewFileReader(dir string) (*FileReader, error) {
+	fr := &FileReader{
+		content:  make(map[string]Content),
+		internal: make(map[string]Content),
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		models := fr.content
+		if rel, _ := filepath.Rel(dir, path); strings.HasPrefix(rel, internalContentDir+string(filepath.Separator)) {
+			models = fr.internal
+		}
+		return loadModels(path, models)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read models from %v", dir)
+	}
+	return fr, nil
+}
+
+func loadModels(path string, models map[string]Content) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var list []Content
+	if err = json.Unmarshal(b, &list); err != nil {
+		var c Content
+		if err = json.Unmarshal(b, &c); err != nil {
+			return errors.Wrapf(err, "Cannot parse %v", path)
+		}
+		list = []Content{c}
+	}
+
+	for _, c := range list {
+		cID, _ := c[id].(string)
+		uuid, err := extractUUIDFromString(cID)
+		if err != nil {
+			continue
+		}
+		models[uuid] = c
+	}
+	return nil
+}
+
+// Get returns the models found for the UUIDs, together with the members of the image sets among them.
+func (fr *FileReader) Get(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	cm := make(map[string]Content)
+	for _, uuid := range uuids {
+		c, found := fr.content[uuid]
+		if !found {
+			continue
+		}
+		cm[uuid] = c.clone()
+		for _, mUUID := range c.getMembersUUID() {
+			if m, found := fr.content[mUUID]; found {
+				cm[mUUID] = m.clone()
+			}
+		}
+	}
+	return cm, nil
+}
+
+func (fr *FileReader) GetInternal(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	cm := make(map[string]Content)
+	for _, uuid := range uuids {
+		if c, found := fr.internal[uuid]; found {
+			cm[uuid] = c.clone()
+		} else if c, found := fr.content[uuid]; found {
+			cm[uuid] = c.clone()
+		}
+	}
+	return cm, nil
+}
+
+// UnrollArticle unrolls the JSON article like the /content endpoint, or like /internalcontent when internal is set,
+// outside of the HTTP server. expand is the comma separated list of optional expansions, as in "?expand=".
+func UnrollArticle(ctx context.Context, u Unroller, article []byte, internal bool, expand string, tid string) (Content, []UnresolvedReference, error) {
+	exp, err := parseExpansionList(expand)
+	if err != nil {
+		return nil, nil, err
+	}
+	event, err := newUnrollEvent(article, tid, exp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unroll, valid := u.UnrollContent, validateContent(event.c)
+	if internal {
+		unroll, valid = u.UnrollInternalContent, validateInternalContent(event.c)
+	}
+	if !valid {
+		return nil, nil, errors.New("Invalid content")
+	}
+
+	res := unroll(ctx, event)
+	return res.uc, res.unresolved, res.err
+}
@@ -0,0 +1,80 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeModels(t *testing.T, path string, v interface{}) {
+	b, err := json.Marshal(v)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, ioutil.WriteFile(path, b, 0644))
+}
+
+func fileReaderForTest(t *testing.T) (*FileReader, string) {
+	dir, err := ioutil.TempDir("", "content-unroller")
+	assert.NoError(t, err)
+
+	models := imageSetModels()
+	writeModels(t, filepath.Join(dir, "imageset.json"), []Content{models[imageSetUUID], models[memberUUID]})
+	writeModels(t, filepath.Join(dir, "other.json"), models[otherUUID])
+	writeModels(t, filepath.Join(dir, internalContentDir, "other.json"), Content{id: "http://www.ft.com/thing/" + otherUUID, "internal": true})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not a model"), 0644))
+
+	fr, err := NewFileReader(dir)
+	assert.NoError(t, err)
+	return fr, dir
+}
+
+func TestFileReader_Get(t *testing.T) {
+	fr, dir := fileReaderForTest(t)
+	defer os.RemoveAll(dir)
+
+	res, err := fr.Get(context.Background(), []string{imageSetUUID, otherUUID, missingUUID}, "tid_sample")
+	assert.NoError(t, err)
+	assert.Len(t, res, 3, "The members of the image set should be returned too")
+	assert.Contains(t, res, memberUUID)
+	assert.NotContains(t, res[otherUUID], "internal")
+
+	res[otherUUID]["title"] = "changed"
+	res, _ = fr.Get(context.Background(), []string{otherUUID}, "tid_sample")
+	assert.NotContains(t, res[otherUUID], "title", "The models read should be copies")
+}
+
+func TestFileReader_GetInternalFallsBackToContent(t *testing.T) {
+	fr, dir := fileReaderForTest(t)
+	defer os.RemoveAll(dir)
+
+	res, err := fr.GetInternal(context.Background(), []string{otherUUID, memberUUID}, "tid_sample")
+	assert.NoError(t, err)
+	assert.Equal(t, true, res[otherUUID]["internal"])
+	assert.Contains(t, res, memberUUID)
+}
+
+func TestNewFileReader_InvalidModels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-unroller")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"id":`), 0644))
+
+	_, err = NewFileReader(dir)
+	assert.Error(t, err)
+}
+
+func TestUnrollArticle_RejectsInvalidContent(t *testing.T) {
+	fr, dir := fileReaderForTest(t)
+	defer os.RemoveAll(dir)
+	cu := NewContentUnroller(fr, UnrollerConfig{APIHost: "test.api.ft.com"})
+
+	_, _, err := UnrollArticle(context.Background(), cu, []byte(`{"id":"http://www.ft.com/thing/`+otherUUID+`"}`), true, "", "tid_sample")
+	assert.Error(t, err)
+	_, _, err = UnrollArticle(context.Background(), cu, []byte(`{"id":"http://www.ft.com/thing/`+otherUUID+`","bodyXML":"<body></body>"}`), false, "everything", "tid_sample")
+	assert.Error(t, err, "Unknown expansions should be rejected")
+}
@@ -0,0 +1,79 @@
+package content
+
+import (
+	"sync"
+)
+
+// flightCall is a backend lookup of a single UUID shared by all concurrent callers requesting it.
+type flightCall struct {
+	done  chan struct{}
+	c     Content
+	found bool
+	err   error
+	// cancelled is set when the lookup failed because the context of the caller owning it was cancelled
+	cancelled bool
+}
+
+// flightGroup de-duplicates concurrent lookups of the same UUID against the same endpoint.
+type flightGroup struct {
+	sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// join registers the caller for each of the given UUIDs. It returns the calls the caller is responsible for
+// (owned) and the calls already in flight for other callers (shared), both keyed by UUID.
+func (g *flightGroup) join(endpoint string, uuids []string) (owned map[string]*flightCall, shared map[string]*flightCall) {
+	owned = make(map[string]*flightCall)
+	shared = make(map[string]*flightCall)
+
+	g.Lock()
+	defer g.Unlock()
+	for _, uuid := range uuids {
+		if _, found := owned[uuid]; found {
+			continue
+		}
+		key := endpoint + "/" + uuid
+		if call, found := g.calls[key]; found {
+			shared[uuid] = call
+			continue
+		}
+		call := &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		owned[uuid] = call
+	}
+	return owned, shared
+}
+
+// complete publishes the result of a backend call to everyone waiting on the owned calls.
+func (g *flightGroup) complete(endpoint string, owned map[string]*flightCall, cb []Content, err error, cancelled bool) {
+	byUUID := make(map[string]Content)
+	for _, c := range cb {
+		id, ok := c[id].(string)
+		if !ok {
+			continue
+		}
+		uuid, uErr := extractUUIDFromString(id)
+		if uErr != nil {
+			continue
+		}
+		byUUID[uuid] = c
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	for uuid, call := range owned {
+		if c, found := byUUID[uuid]; found && err == nil {
+			// waiters get their own copy so the caller owning the call is free to modify the original
+			call.c = c.clone()
+			call.found = true
+		}
+		call.err = err
+		call.cancelled = cancelled
+		delete(g.calls, endpoint+"/"+uuid)
+		close(call.done)
+	}
+}
@@ -1,10 +1,11 @@
 package content
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"time"
 
 	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
 	"github.com/pkg/errors"
@@ -14,21 +15,29 @@ type ErrorMessage struct {
 	Message string `json:"message"`
 }
 
+// RequestTimeoutHeader overrides the default time budget of a request, e.g. "X-Request-Timeout: 500ms".
+const RequestTimeoutHeader = "X-Request-Timeout"
+
 var logger = NewAppLogger()
 
 type Handler struct {
 	Service Unroller
+	Cache   *ResponseCache
+	// Timeout is the default time budget for unrolling a request. There is no deadline when it is 0.
+	Timeout time.Duration
 }
 
 type UnrollEvent struct {
-	c    Content
-	tid  string
-	uuid string
+	c      Content
+	tid    string
+	uuid   string
+	expand expansions
 }
 
 type UnrollResult struct {
-	uc  Content
-	err error
+	uc         Content
+	err        error
+	unresolved []UnresolvedReference
 }
 
 func (hh *Handler) GetContent(w http.ResponseWriter, r *http.Request) {
@@ -45,22 +54,7 @@ func (hh *Handler) GetContent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid)
-
-	res := hh.Service.UnrollContent(event)
-	if res.err != nil {
-		handleError(r, tid, event.uuid, w, res.err, http.StatusInternalServerError)
-		return
-	}
-
-	jsonRes, err := json.Marshal(res.uc)
-	if err != nil {
-		handleError(r, tid, event.uuid, w, err, http.StatusInternalServerError)
-		return
-	}
-
-	logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusOK, event.uuid, "success")
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(jsonRes)
+	hh.serveUnrolled(w, r, event, "content", hh.Service.UnrollContent)
 }
 
 func (hh *Handler) GetInternalContent(w http.ResponseWriter, r *http.Request) {
@@ -68,6 +62,7 @@ func (hh *Handler) GetInternalContent(w http.ResponseWriter, r *http.Request) {
 	event, err := createUnrollEvent(r, tid)
 	if err != nil {
 		handleError(r, tid, "", w, err, http.StatusBadRequest)
+		return
 	}
 
 	if !validateInternalContent(event.c) {
@@ -76,33 +71,161 @@ func (hh *Handler) GetInternalContent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid)
+	hh.serveUnrolled(w, r, event, "internalcontent", hh.Service.UnrollInternalContent)
+}
 
-	res := hh.Service.UnrollInternalContent(event)
-	if res.err != nil {
-		handleError(r, tid, event.uuid, w, res.err, http.StatusInternalServerError)
+// serveUnrolled writes the unrolled content for the event, reusing a cached response for the same article when
+// one is available and answering with 304 Not Modified when the client already holds the current version.
+func (hh *Handler) serveUnrolled(w http.ResponseWriter, r *http.Request, event UnrollEvent, endpoint string, unroll func(context.Context, UnrollEvent) UnrollResult) {
+	ctx, cancel, err := hh.requestContext(r)
+	if err != nil {
+		handleError(r, event.tid, event.uuid, w, err, http.StatusBadRequest)
 		return
 	}
+	defer cancel()
 
-	jsonRes, err := json.Marshal(res.uc)
+	articleHash, err := hashArticle(event.c)
 	if err != nil {
-		handleError(r, tid, event.uuid, w, err, http.StatusInternalServerError)
+		handleError(r, event.tid, event.uuid, w, err, http.StatusInternalServerError)
 		return
 	}
+	// the _unroll block, the expansions and the format change the body, so responses with and without them
+	// are different variants
+	variant := articleHash
+	if len(event.expand) > 0 {
+		variant += ":" + expandParam + "=" + event.expand.String()
+	}
+	withReport := r.URL.Query().Get(unrollReportParam) == "true"
+	if withReport {
+		variant += ":" + unrollReportField
+	}
+	normalised, err := parseNormalised(r)
+	if err != nil {
+		handleError(r, event.tid, event.uuid, w, err, http.StatusBadRequest)
+		return
+	}
+	if normalised {
+		variant += ":" + formatParam + "=" + normalisedFormat
+	}
+	cacheKey := endpoint + ":" + variant
+
+	cached, found := hh.Cache.get(cacheKey)
+	if !found {
+		gen := hh.Cache.currentGeneration()
+		res := unroll(ctx, event)
+		if res.err != nil {
+			status := http.StatusInternalServerError
+			if ctx.Err() == context.DeadlineExceeded || isTimeout(res.err) {
+				status = http.StatusGatewayTimeout
+			}
+			handleError(r, event.tid, event.uuid, w, res.err, status)
+			return
+		}
 
-	logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusOK, event.uuid, "success")
+		uc := res.uc
+		if normalised {
+			uc = normalise(uc)
+		}
+		if withReport {
+			uc = withUnrollReport(uc, res.unresolved)
+		}
+		jsonRes, err := json.Marshal(uc)
+		if err != nil {
+			handleError(r, event.tid, event.uuid, w, err, http.StatusInternalServerError)
+			return
+		}
+
+		uuids := collectUUIDs(res.uc)
+		cached = cachedResponse{uuid: event.uuid, body: jsonRes, etag: computeETag(variant, res.uc), unresolved: res.unresolved, resolved: countResolved(uuids, event.uuid, res.unresolved)}
+		// a response missing models because a backend failed or the time budget ran out is not kept, as the next
+		// request may well resolve them
+		if ctx.Err() == nil && !hasBackendError(res.unresolved) {
+			hh.Cache.set(cacheKey, cached, uuids, gen)
+		}
+	}
+	requestStatsFrom(r.Context()).setResolvedUUIDs(cached.resolved)
+
+	etag := cached.etag
+	enc, encoded := responseEncoding(r, cached.body)
+	if encoded {
+		etag = encodedETag(cached.etag, enc)
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Set("ETag", etag)
+	if len(cached.unresolved) > 0 {
+		w.Header().Set(UnrollMissingHeader, formatUnresolvedHeader(cached.unresolved))
+	}
+	// the encodings of a response have the same content, so any of their ETags is current
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if etagMatches(ifNoneMatch, etag) || etagMatches(ifNoneMatch, cached.etag) {
+		logger.TransactionFinishedEvent(r.RequestURI, event.tid, http.StatusNotModified, event.uuid, "not modified")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body := cached.body
+	if encoded {
+		if body, err = encodeBody(enc, cached.body); err != nil {
+			handleError(r, event.tid, event.uuid, w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Encoding", enc.Name)
+	}
+	logger.TransactionFinishedEvent(r.RequestURI, event.tid, http.StatusOK, event.uuid, "success")
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(jsonRes)
+	w.Write(body)
+}
+
+// isTimeout tells whether the error comes from a read running out of time, e.g. the first fetch of a reader
+// running out of its share of the budget.
+func isTimeout(err error) bool {
+	cause := errors.Cause(err)
+	if cause == context.DeadlineExceeded {
+		return true
+	}
+	t, ok := cause.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// requestContext returns the context bounding the time spent unrolling the request. It is cancelled when
+// the client goes away or the time budget, configured or supplied in RequestTimeoutHeader, runs out.
+// The budget supplied can only shorten the configured one.
+func (hh *Handler) requestContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	timeout := hh.Timeout
+	if h := r.Header.Get(RequestTimeoutHeader); h != "" {
+		t, err := time.ParseDuration(h)
+		if err != nil || t <= 0 {
+			return nil, nil, requestError{http.StatusBadRequest, fmt.Sprintf("Invalid %s header: %s", RequestTimeoutHeader, h)}
+		}
+		if timeout <= 0 || t < timeout {
+			timeout = t
+		}
+	}
+
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
 }
 
 func createUnrollEvent(r *http.Request, tid string) (UnrollEvent, error) {
-	var unrollEvent UnrollEvent
-	b, err := ioutil.ReadAll(r.Body)
+	b, err := decodeBody(r.Header.Get("Content-Encoding"), r.Body)
 	if err != nil {
-		return unrollEvent, err
+		return UnrollEvent{}, err
 	}
+	expand, err := parseExpansions(r)
+	if err != nil {
+		return UnrollEvent{}, err
+	}
+	return newUnrollEvent(b, tid, expand)
+}
 
+func newUnrollEvent(b []byte, tid string, expand expansions) (UnrollEvent, error) {
+	var unrollEvent UnrollEvent
 	var article Content
-	err = json.Unmarshal(b, &article)
+	err := json.Unmarshal(b, &article)
 	if err != nil {
 		return unrollEvent, err
 	}
@@ -115,24 +238,41 @@ func createUnrollEvent(r *http.Request, tid string) (UnrollEvent, error) {
 	if err != nil {
 		return unrollEvent, err
 	}
-	unrollEvent = UnrollEvent{article, tid, uuid}
+	unrollEvent = UnrollEvent{article, tid, uuid, expand}
 
 	return unrollEvent, nil
 }
 
+// requestError is a request rejected for another reason than its content, answered with its own status and message.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e requestError) Error() string {
+	return e.msg
+}
+
 func handleError(r *http.Request, tid string, uuid string, w http.ResponseWriter, err error, statusCode int) {
 	var errMsg string
-	if statusCode >= 400 && statusCode < 500 {
+	if re, ok := errors.Cause(err).(requestError); ok {
+		statusCode, errMsg = re.status, re.msg
+	} else if statusCode >= 400 && statusCode < 500 {
 		errMsg = fmt.Sprintf("Error expanding content, supplied UUID is invalid: %s", err.Error())
-		logger.Errorf(tid, errMsg)
 	} else if statusCode >= 500 {
 		errMsg = fmt.Sprintf("Error expanding content for: %v: %v", uuid, err.Error())
-		logger.TransactionFinishedEvent(r.RequestURI, tid, statusCode, uuid, err.Error())
 	}
+	logger.TransactionFinishedEvent(r.RequestURI, tid, statusCode, uuid, err.Error())
 	w.WriteHeader(statusCode)
 	w.Write([]byte(errMsg))
 }
 
+func writeErrorMessage(w http.ResponseWriter, msg string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(ErrorMessage{Message: msg})
+}
+
 func validateContent(article Content) bool {
 	_, hasMainImage := article[mainImage]
 	_, hasBody := article[bodyXML]
@@ -2,12 +2,14 @@ package content
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -19,31 +21,31 @@ const (
 )
 
 type ContentUnrollerMock struct {
-	mockUnrollContent         func(UnrollEvent) UnrollResult
-	mockUnrollInternalContent func(UnrollEvent) UnrollResult
+	mockUnrollContent         func(context.Context, UnrollEvent) UnrollResult
+	mockUnrollInternalContent func(context.Context, UnrollEvent) UnrollResult
 }
 
-func (cu *ContentUnrollerMock) UnrollContent(req UnrollEvent) UnrollResult {
-	return cu.mockUnrollContent(req)
+func (cu *ContentUnrollerMock) UnrollContent(ctx context.Context, req UnrollEvent) UnrollResult {
+	return cu.mockUnrollContent(ctx, req)
 }
 
-func (cu *ContentUnrollerMock) UnrollInternalContent(req UnrollEvent) UnrollResult {
-	return cu.mockUnrollInternalContent(req)
+func (cu *ContentUnrollerMock) UnrollInternalContent(ctx context.Context, req UnrollEvent) UnrollResult {
+	return cu.mockUnrollInternalContent(ctx, req)
 }
 
 func TestGetContentReturns200(t *testing.T) {
 	cu := ContentUnrollerMock{
-		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
 			var r Content
 			fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-response.json")
 			assert.NoError(t, err, "Cannot read resources test file")
 			err = json.Unmarshal(fileBytes, &r)
 			assert.NoError(t, err, "Cannot build json body")
-			return UnrollResult{r, nil}
+			return UnrollResult{r, nil, nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
@@ -62,8 +64,196 @@ func TestGetContentReturns200(t *testing.T) {
 	assert.JSONEq(t, string(expectedBody), string(actualBody.Bytes()))
 }
 
+func TestGetContent_ReturnsNotModifiedWhenETagMatches(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag, "Response should carry an ETag")
+
+	req, err = http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, etag, rr.Header().Get("ETag"))
+	assert.Empty(t, rr.Body.Bytes())
+}
+
+func TestGetContent_ServesCachedResponse(t *testing.T) {
+	calls := 0
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			calls++
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+
+	h := Handler{Service: &cu, Cache: NewResponseCache(time.Minute, 10)}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	var etags []string
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+		assert.NoError(t, err, "Cannot create request necessary for test")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, string(body), rr.Body.String())
+		etags = append(etags, rr.Header().Get("ETag"))
+	}
+
+	assert.Equal(t, 1, calls, "Content should be unrolled only once")
+	assert.Equal(t, etags[0], etags[1])
+}
+
+func TestGetContent_DoesNotCacheIncompleteResponses(t *testing.T) {
+	results := map[string]func(ctx context.Context, req UnrollEvent) UnrollResult{
+		"backend error": func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{req.c, nil, []UnresolvedReference{{UUID: imageSetUUID, Path: mainImage, Reason: reasonBackendError}}}
+		},
+		"time budget run out": func(ctx context.Context, req UnrollEvent) UnrollResult {
+			<-ctx.Done()
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	for name, unroll := range results {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			cu := ContentUnrollerMock{
+				mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+					calls++
+					return unroll(ctx, req)
+				},
+			}
+			h := Handler{Service: &cu, Cache: NewResponseCache(time.Minute, 10), Timeout: 10 * time.Millisecond}
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+				rr := httptest.NewRecorder()
+				http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+				assert.Equal(t, http.StatusOK, rr.Code)
+			}
+			assert.Equal(t, 2, calls, "Incomplete response shouldn't be cached")
+		})
+	}
+}
+
+func TestGetContent_AppliesRequestTimeout(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok, "Context should have a deadline")
+			assert.WithinDuration(t, time.Now().Add(500*time.Millisecond), deadline, 100*time.Millisecond)
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+
+	h := Handler{Service: &cu, Timeout: 10 * time.Second}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	req.Header.Set(RequestTimeoutHeader, "500ms")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestGetContent_InvalidRequestTimeout(t *testing.T) {
+	h := Handler{Service: &ContentUnrollerMock{}}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	req.Header.Set(RequestTimeoutHeader, "soon")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestGetContent_ReturnsGatewayTimeoutWhenBudgetRunsOut(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			<-ctx.Done()
+			return UnrollResult{nil, ctx.Err(), nil}
+		},
+	}
+
+	h := Handler{Service: &cu, Timeout: 10 * time.Millisecond}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+}
+
+func TestGetContent_CapsRequestTimeout(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok, "Context should have a deadline")
+			assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+
+	h := Handler{Service: &cu, Timeout: time.Second}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	req.Header.Set(RequestTimeoutHeader, "1h")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestGetContent_ReturnsGatewayTimeoutWhenReadRunsOut(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			readCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+			defer cancel()
+			<-readCtx.Done()
+			return UnrollResult{nil, errors.Wrap(readCtx.Err(), "Error while getting expanded content"), nil}
+		},
+	}
+
+	h := Handler{Service: &cu, Timeout: 10 * time.Second}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+}
+
 func TestGetContent_UnrollEventError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{}
 	req, err := http.NewRequest(http.MethodPost, "/content", strings.NewReader("sample body"))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -76,7 +266,7 @@ func TestGetContent_UnrollEventError(t *testing.T) {
 }
 
 func TestGetContent_UnrollEventError_MissingID(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{}
 	req, err := http.NewRequest(http.MethodPost, "/content", strings.NewReader(invalidBodyMissingID))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -89,7 +279,7 @@ func TestGetContent_UnrollEventError_MissingID(t *testing.T) {
 }
 
 func TestGetContent_ValidationError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{}
 	req, err := http.NewRequest(http.MethodPost, "/content", strings.NewReader(InvalidBodyRequest))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -103,12 +293,12 @@ func TestGetContent_ValidationError(t *testing.T) {
 
 func TestGetContent_UnrollingError(t *testing.T) {
 	cu := ContentUnrollerMock{
-		mockUnrollContent: func(req UnrollEvent) UnrollResult {
-			return UnrollResult{nil, errors.New("Error while unrolling content")}
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{nil, errors.New("Error while unrolling content"), nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
@@ -124,17 +314,17 @@ func TestGetContent_UnrollingError(t *testing.T) {
 
 func TestGetInternalContentReturns200(t *testing.T) {
 	cu := ContentUnrollerMock{
-		mockUnrollInternalContent: func(req UnrollEvent) UnrollResult {
+		mockUnrollInternalContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
 			var r Content
 			fileBytes, err := ioutil.ReadFile("../test-resources/internalcontent-valid-response.json")
 			assert.NoError(t, err, "Cannot read test file")
 			err = json.Unmarshal(fileBytes, &r)
 			assert.NoError(t, err, "Cannot build json body")
-			return UnrollResult{r, nil}
+			return UnrollResult{r, nil, nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", bytes.NewReader(body))
@@ -152,7 +342,7 @@ func TestGetInternalContentReturns200(t *testing.T) {
 }
 
 func TestGetInternalContent_UnrollEventError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", strings.NewReader("sample body"))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -165,7 +355,7 @@ func TestGetInternalContent_UnrollEventError(t *testing.T) {
 }
 
 func TestGetInternalContent_UnrollEventError_MissingID(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", strings.NewReader(invalidBodyMissingID))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -178,7 +368,7 @@ func TestGetInternalContent_UnrollEventError_MissingID(t *testing.T) {
 }
 
 func TestGetInternalContent_ValidationError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", strings.NewReader(InvalidBodyRequest))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -192,12 +382,12 @@ func TestGetInternalContent_ValidationError(t *testing.T) {
 
 func TestGetInternalContent_UnrollingError(t *testing.T) {
 	cu := ContentUnrollerMock{
-		mockUnrollInternalContent: func(req UnrollEvent) UnrollResult {
-			return UnrollResult{nil, errors.New("Error while unrolling content")}
+		mockUnrollInternalContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{nil, errors.New("Error while unrolling content"), nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", bytes.NewReader(body))
@@ -210,3 +400,59 @@ func TestGetInternalContent_UnrollingError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	assert.Contains(t, string(rr.Body.Bytes()), "Error while unrolling content")
 }
+
+func TestGetContent_ReportsUnresolvedReferences(t *testing.T) {
+	unresolved := []UnresolvedReference{
+		{UUID: memberUUID, Path: "mainImage.members[0]", Reason: reasonNotFound},
+		{UUID: "not-a-uuid", Path: "alternativeImages.promotionalImage", Reason: reasonInvalidUUID},
+	}
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{req.c, nil, unresolved}
+		},
+	}
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	req := httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "mainImage.members[0];uuid="+memberUUID+";reason=not_found, "+
+		"alternativeImages.promotionalImage;uuid=not-a-uuid;reason=invalid_uuid", rr.Header().Get(UnrollMissingHeader))
+	assert.NotContains(t, rr.Body.String(), unrollReportField, "Report should only be in the body when asked for")
+
+	req = httptest.NewRequest(http.MethodPost, "/content?unrollReport=true", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var res struct {
+		Unroll struct {
+			Unresolved []UnresolvedReference `json:"unresolved"`
+		} `json:"_unroll"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	assert.Equal(t, unresolved, res.Unroll.Unresolved)
+}
+
+func TestGetContent_OmitsMissingHeaderWhenEverythingIsResolved(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	req := httptest.NewRequest(http.MethodPost, "/content?unrollReport=true", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get(UnrollMissingHeader))
+	assert.Contains(t, rr.Body.String(), `"_unroll":{"unresolved":[]}`)
+}
@@ -1,31 +1,167 @@
 package content
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	"github.com/Financial-Times/service-status-go/gtg"
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
 	"github.com/pkg/errors"
 )
 
 type ServiceConfig struct {
 	ContentStoreAppName      string
 	ContentStoreAppHealthURI string
+	PreviewStoreAppName      string
+	PreviewStoreAppHealthURI string
 	HTTPClient               *http.Client
+	// CanaryUUID is the content read by the canary checks, which aren't run when empty.
+	CanaryUUID string
+	// CanaryReader is the reader of the canary, which must call the content store rather than a cache.
+	CanaryReader Reader
+	// CanaryLatencyThreshold is the latency over which a canary read is reported as slow.
+	CanaryLatencyThreshold time.Duration
+	// Sources are the content sources the models are read from, replacing the content store in the healthchecks
+	// and the gtg when set.
+	Sources  []SourceConfig
+	draining int32
+}
+
+// canaryResultTTL is how long a canary read is shared by the checks of an endpoint.
+const canaryResultTTL = 5 * time.Second
+
+type canaryRead func(ctx context.Context, uuids []string, tid string) (map[string]Content, error)
+
+type canaryResult struct {
+	at      time.Time
+	latency time.Duration
+	err     error
+}
+
+// canaryProbe reads the canary once for the shape check and the latency check of an endpoint.
+type canaryProbe struct {
+	sync.Mutex
+	read canaryRead
+	uuid string
+	last *canaryResult
+}
+
+func (p *canaryProbe) run() canaryResult {
+	p.Lock()
+	defer p.Unlock()
+	if p.last != nil && time.Since(p.last.at) < canaryResultTTL {
+		return *p.last
+	}
+
+	start := time.Now()
+	cm, err := p.read(context.Background(), []string{p.uuid}, transactionidutils.NewTransactionID())
+	r := canaryResult{at: time.Now(), latency: time.Since(start), err: err}
+	if err == nil {
+		r.err = validateCanary(cm, p.uuid)
+	}
+	p.last = &r
+	return r
+}
+
+// validateCanary checks that the canary was returned with its id and a type, as a model unrolling relies on.
+func validateCanary(cm map[string]Content, uuid string) error {
+	c, found := cm[uuid]
+	if !found {
+		return errors.Errorf("Canary %s not found", uuid)
+	}
+	cID, _ := c[id].(string)
+	if cUUID, err := extractUUIDFromString(cID); err != nil || cUUID != uuid {
+		return errors.Errorf("Canary %s returned with invalid id %q", uuid, cID)
+	}
+	if t, _ := c["type"].(string); t == "" {
+		return errors.Errorf("Canary %s returned without a type", uuid)
+	}
+	return nil
+}
+
+// StartDraining fails the gtg from now on, so the instance is taken out of the load balancer before it stops.
+func (sc *ServiceConfig) StartDraining() {
+	atomic.StoreInt32(&sc.draining, 1)
 }
 
 func (sc *ServiceConfig) GtgCheck() gtg.Status {
-	contentStoreCheck := func() gtg.Status {
+	return sc.gtgCheck(sc.contentStoreGtgCheck)
+}
+
+// CachedGtgCheck is GtgCheck with the content store availability served from cc. The shutdown is still reported
+// straight away.
+func (sc *ServiceConfig) CachedGtgCheck(cc *CheckCache) gtg.StatusChecker {
+	contentStoreCheck := cc.Gtg(sc.contentStoreGtgCheck)
+	return func() gtg.Status {
+		return sc.gtgCheck(contentStoreCheck)
+	}
+}
+
+func (sc *ServiceConfig) gtgCheck(contentStoreCheck gtg.StatusChecker) gtg.Status {
+	if atomic.LoadInt32(&sc.draining) == 1 {
+		return gtg.Status{GoodToGo: false, Message: "Shutting down"}
+	}
+	return gtg.FailFastParallelCheck([]gtg.StatusChecker{
+		contentStoreCheck,
+	})()
+}
+
+// contentStoreGtgCheck fails when the content store is unavailable. With several sources, it only fails when all
+// of them are, as the models are read from the next source when one fails.
+func (sc *ServiceConfig) contentStoreGtgCheck() gtg.Status {
+	if len(sc.Sources) == 0 {
 		msg, err := sc.checkServiceAvailability(sc.ContentStoreAppName, sc.ContentStoreAppHealthURI)
 		if err != nil {
 			return gtg.Status{GoodToGo: false, Message: msg}
 		}
 		return gtg.Status{GoodToGo: true}
 	}
-	return gtg.FailFastParallelCheck([]gtg.StatusChecker{
-		contentStoreCheck,
-	})()
+
+	var failures []string
+	for _, s := range sc.Sources {
+		_, err := sc.checkServiceAvailability(s.AppName, sourceHealthURI(s))
+		if err == nil {
+			return gtg.Status{GoodToGo: true}
+		}
+		failures = append(failures, err.Error())
+	}
+	return gtg.Status{GoodToGo: false, Message: strings.Join(failures, "; ")}
+}
+
+// ContentStoreChecks return the check of the content store, or one check per source when the sources are set.
+func (sc *ServiceConfig) ContentStoreChecks() []fthealth.Check {
+	if len(sc.Sources) == 0 {
+		return []fthealth.Check{sc.ContentStoreCheck()}
+	}
+	var checks []fthealth.Check
+	for _, s := range sc.Sources {
+		checks = append(checks, sc.sourceCheck(s))
+	}
+	return checks
+}
+
+func (sc *ServiceConfig) sourceCheck(s SourceConfig) fthealth.Check {
+	return fthealth.Check{
+		ID:               fmt.Sprintf("check-connect-source-%s", s.Name),
+		Name:             fmt.Sprintf("Check connectivity to the %s content source (%s)", s.Name, s.AppName),
+		Severity:         1,
+		BusinessImpact:   fmt.Sprintf("Images and dynamic content routed to the %s source won't be unrolled unless found in the next source", s.Name),
+		TechnicalSummary: fmt.Sprintf(`Cannot connect to %v at %v.`, s.AppName, s.Host),
+		PanicGuide:       "https://dewey.in.ft.com/runbooks/contentreadapi",
+		Checker: func() (string, error) {
+			return sc.checkServiceAvailability(s.AppName, sourceHealthURI(s))
+		},
+	}
+}
+
+func sourceHealthURI(s SourceConfig) string {
+	return s.Host + "/__health"
 }
 
 func (sc *ServiceConfig) ContentStoreCheck() fthealth.Check {
@@ -42,6 +178,82 @@ func (sc *ServiceConfig) ContentStoreCheck() fthealth.Check {
 	}
 }
 
+// PreviewStoreCheck doesn't take part in the gtg, as the published content endpoints don't depend on the preview store.
+func (sc *ServiceConfig) PreviewStoreCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:               fmt.Sprintf("check-connect-%s", sc.PreviewStoreAppName),
+		Name:             fmt.Sprintf("Check connectivity to %s", sc.PreviewStoreAppName),
+		Severity:         2,
+		BusinessImpact:   "Preview of unrolled images and dynamic content won't be available",
+		TechnicalSummary: fmt.Sprintf(`Cannot connect to %v.`, sc.PreviewStoreAppName),
+		PanicGuide:       "https://dewey.in.ft.com/runbooks/contentreadapi",
+		Checker: func() (string, error) {
+			return sc.checkServiceAvailability(sc.PreviewStoreAppName, sc.PreviewStoreAppHealthURI)
+		},
+	}
+}
+
+// ContentCanaryChecks read the canary from the content endpoint of the content store, or of the sources when set,
+// checking the model returned and, separately, the latency of the read.
+func (sc *ServiceConfig) ContentCanaryChecks() []fthealth.Check {
+	p := &canaryProbe{read: sc.CanaryReader.Get, uuid: sc.CanaryUUID}
+	return sc.canaryChecks("content", "Unrolled images and dynamic content won't be available", p)
+}
+
+// InternalContentCanaryChecks read the canary from the internal content endpoint of the content store, or of the
+// sources when set, checking the model returned and, separately, the latency of the read.
+func (sc *ServiceConfig) InternalContentCanaryChecks() []fthealth.Check {
+	p := &canaryProbe{read: sc.CanaryReader.GetInternal, uuid: sc.CanaryUUID}
+	return sc.canaryChecks("internalcontent", "Unrolled internal content won't be available", p)
+}
+
+// canarySource names what the canary is read from, the content store or the content sources when they're set.
+func (sc *ServiceConfig) canarySource() string {
+	if len(sc.Sources) == 0 {
+		return sc.ContentStoreAppName
+	}
+	var names []string
+	for _, s := range sc.Sources {
+		names = append(names, s.Name)
+	}
+	return "sources-" + strings.Join(names, "-")
+}
+
+func (sc *ServiceConfig) canaryChecks(endpoint string, impact string, p *canaryProbe) []fthealth.Check {
+	source := sc.canarySource()
+	return []fthealth.Check{
+		{
+			ID:               fmt.Sprintf("check-%s-canary-%s", endpoint, source),
+			Name:             fmt.Sprintf("Check %s canary read from %s", endpoint, source),
+			Severity:         1,
+			BusinessImpact:   impact,
+			TechnicalSummary: fmt.Sprintf(`The %v endpoint of %v doesn't return a valid model for the canary %v.`, endpoint, source, sc.CanaryUUID),
+			PanicGuide:       "https://dewey.in.ft.com/runbooks/contentreadapi",
+			Checker: func() (string, error) {
+				if r := p.run(); r.err != nil {
+					return "Error", errors.Wrapf(r.err, "%s read from %s failed", endpoint, source)
+				}
+				return "Ok", nil
+			},
+		},
+		{
+			ID:               fmt.Sprintf("check-%s-canary-latency-%s", endpoint, source),
+			Name:             fmt.Sprintf("Check %s canary read latency from %s", endpoint, source),
+			Severity:         2,
+			BusinessImpact:   "Unrolling is slower than expected and requests may time out",
+			TechnicalSummary: fmt.Sprintf(`Reading the canary %v from the %v endpoint of %v takes longer than %v.`, sc.CanaryUUID, endpoint, source, sc.CanaryLatencyThreshold),
+			PanicGuide:       "https://dewey.in.ft.com/runbooks/contentreadapi",
+			Checker: func() (string, error) {
+				r := p.run()
+				if r.latency > sc.CanaryLatencyThreshold {
+					return "Error", errors.Errorf("%s read from %s took %v, over %v", endpoint, source, r.latency, sc.CanaryLatencyThreshold)
+				}
+				return fmt.Sprintf("%v", r.latency), nil
+			},
+		},
+	}
+}
+
 func (sc *ServiceConfig) checkServiceAvailability(serviceName string, healthURI string) (string, error) {
 	req, err := http.NewRequest(http.MethodGet, healthURI, nil)
 	resp, err := sc.HTTPClient.Do(req)
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -55,6 +56,45 @@ func TestServiceConfig_ContentStoreCheck_InvalidAddress(t *testing.T) {
 	assert.Error(t, err, "dial tcp: lookup sampleHost: no such host")
 }
 
+func TestServiceConfig_ChecksEverySource(t *testing.T) {
+	up := startFunctionalService()
+	defer up.Close()
+	down := startNotFunctionalService()
+	defer down.Close()
+	sc := initTestServiceConfig("http://sampleHost:8080")
+	sc.Sources = []SourceConfig{
+		{Name: "published", AppName: "content-public-read", Host: down.URL},
+		{Name: "archive", AppName: "content-archive-read", Host: up.URL},
+	}
+
+	checks := sc.ContentStoreChecks()
+	assert.Len(t, checks, 2)
+	_, err := checks[0].Checker()
+	assert.Error(t, err)
+	out, err := checks[1].Checker()
+	assert.NoError(t, err)
+	assert.Equal(t, "Ok", out)
+	assert.True(t, sc.GtgCheck().GoodToGo, "The gtg should pass while a source is available")
+
+	sc.Sources[1].Host = down.URL
+	assert.False(t, sc.GtgCheck().GoodToGo, "The gtg should fail when no source is available")
+}
+
+func TestServiceConfig_PreviewStoreCheck_NotHealthy(t *testing.T) {
+	ts := startNotFunctionalService()
+	defer ts.Close()
+	sc := ServiceConfig{
+		PreviewStoreAppName:      "preview-source-app",
+		PreviewStoreAppHealthURI: ts.URL,
+		HTTPClient:               http.DefaultClient,
+	}
+
+	check := sc.PreviewStoreCheck()
+	_, err := check.Checker()
+	assert.Error(t, err)
+	assert.Equal(t, uint8(2), check.Severity)
+}
+
 func TestServiceConfig_GtgCheck(t *testing.T) {
 	contentStoreTestService := startFunctionalService()
 	defer contentStoreTestService.Close()
@@ -74,3 +114,76 @@ func TestServiceConfig_GtgCheck_NotGtg(t *testing.T) {
 	status := sc.GtgCheck()
 	assert.Equal(t, false, status.GoodToGo)
 }
+
+func canaryServiceConfig(get func(uuids []string, tid string) (map[string]Content, error)) ServiceConfig {
+	sc := initTestServiceConfig("")
+	sc.CanaryUUID = parentUUID
+	sc.CanaryReader = &ReaderMock{mockGet: get, mockGetInternal: get}
+	sc.CanaryLatencyThreshold = time.Second
+	return sc
+}
+
+func TestServiceConfig_ContentCanaryChecks(t *testing.T) {
+	calls := 0
+	sc := canaryServiceConfig(func(uuids []string, tid string) (map[string]Content, error) {
+		calls++
+		return map[string]Content{parentUUID: {id: "http://www.ft.com/thing/" + parentUUID, "type": "http://www.ft.com/ontology/content/Article"}}, nil
+	})
+
+	checks := sc.ContentCanaryChecks()
+	assert.Len(t, checks, 2)
+	out, err := checks[0].Checker()
+	assert.NoError(t, err)
+	assert.Equal(t, "Ok", out)
+	_, err = checks[1].Checker()
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(2), checks[1].Severity)
+	assert.Equal(t, 1, calls, "the checks of an endpoint should share the canary read")
+}
+
+func TestServiceConfig_CanaryChecksNameTheSources(t *testing.T) {
+	sc := canaryServiceConfig(func(uuids []string, tid string) (map[string]Content, error) {
+		return nil, nil
+	})
+	sc.Sources = []SourceConfig{{Name: "primary"}, {Name: "fallback"}}
+
+	checks := sc.ContentCanaryChecks()
+	assert.Equal(t, "check-content-canary-sources-primary-fallback", checks[0].ID)
+	assert.Equal(t, "check-content-canary-latency-sources-primary-fallback", checks[1].ID)
+	assert.NotContains(t, checks[0].Name, sc.ContentStoreAppName)
+}
+
+func TestServiceConfig_InternalContentCanaryChecks_InvalidModel(t *testing.T) {
+	tests := map[string]map[string]Content{
+		"missing":      {},
+		"wrong id":     {parentUUID: {id: "http://www.ft.com/thing/" + otherUUID, "type": "http://www.ft.com/ontology/content/Article"}},
+		"missing type": {parentUUID: {id: "http://www.ft.com/thing/" + parentUUID}},
+	}
+	for name, cm := range tests {
+		t.Run(name, func(t *testing.T) {
+			sc := canaryServiceConfig(func(uuids []string, tid string) (map[string]Content, error) {
+				return cm, nil
+			})
+
+			checks := sc.InternalContentCanaryChecks()
+			_, err := checks[0].Checker()
+			assert.Error(t, err)
+			_, err = checks[1].Checker()
+			assert.NoError(t, err, "a fast read should pass the latency check")
+		})
+	}
+}
+
+func TestServiceConfig_ContentCanaryChecks_Slow(t *testing.T) {
+	sc := canaryServiceConfig(func(uuids []string, tid string) (map[string]Content, error) {
+		time.Sleep(20 * time.Millisecond)
+		return map[string]Content{parentUUID: {id: "http://www.ft.com/thing/" + parentUUID, "type": "http://www.ft.com/ontology/content/Article"}}, nil
+	})
+	sc.CanaryLatencyThreshold = 10 * time.Millisecond
+
+	checks := sc.ContentCanaryChecks()
+	_, err := checks[0].Checker()
+	assert.NoError(t, err)
+	_, err = checks[1].Checker()
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package content
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/pkg/errors"
+)
+
+// Invalidator evicts cached data for the given UUIDs and returns the UUIDs of the evicted entries.
+type Invalidator interface {
+	Invalidate(uuids []string) []string
+}
+
+type invalidationRequest struct {
+	UUIDs []string `json:"uuids"`
+}
+
+type invalidationResponse struct {
+	Evicted []string `json:"evicted"`
+}
+
+// InvalidationHandler evicts models from every configured cache, e.g. when an image or image set is republished.
+type InvalidationHandler struct {
+	Invalidators []Invalidator
+}
+
+func (ih *InvalidationHandler) Invalidate(w http.ResponseWriter, r *http.Request) {
+	tid := transactionidutils.GetTransactionIDFromRequest(r)
+
+	uuids, err := readInvalidationRequest(r)
+	if err != nil {
+		logger.Errorf(tid, "Invalid invalidation request: %v", err)
+		writeErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	evicted := invalidateAll(ih.Invalidators, uuids, tid)
+	jsonRes, err := json.Marshal(invalidationResponse{Evicted: evicted})
+	if err != nil {
+		writeErrorMessage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write(jsonRes)
+}
+
+func readInvalidationRequest(r *http.Request) ([]string, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var req invalidationRequest
+	if err = json.Unmarshal(b, &req); err != nil {
+		return nil, err
+	}
+	return uuidsFromIDs(req.UUIDs)
+}
+
+// InvalidationWorker evicts cached models for every content notification consumed from a queue.
+type InvalidationWorker struct {
+	Invalidators []Invalidator
+	Consumer     MessageConsumer
+}
+
+// Start consumes notifications until Stop is called.
+func (iw *InvalidationWorker) Start() {
+	iw.Consumer.Consume(iw.handleMessage)
+}
+
+func (iw *InvalidationWorker) Stop() {
+	iw.Consumer.Stop()
+}
+
+func (iw *InvalidationWorker) handleMessage(m Message) {
+	tid := m.Headers[transactionidutils.TransactionIDHeader]
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(m.Body), &event); err != nil {
+		logger.Errorf(tid, "Cannot parse notification: %v", err)
+		return
+	}
+
+	uri, _ := event[contentURI].(string)
+	uuid, err := extractUUIDFromString(uri)
+	if err != nil {
+		logger.Errorf(tid, "Cannot find content UUID in notification: %v", err)
+		return
+	}
+
+	invalidateAll(iw.Invalidators, []string{uuid}, tid)
+}
+
+func invalidateAll(invalidators []Invalidator, uuids []string, tid string) []string {
+	evicted := make(map[string]bool)
+	for _, i := range invalidators {
+		for _, u := range i.Invalidate(uuids) {
+			evicted[u] = true
+		}
+	}
+	res := keys(evicted)
+	logger.Infof(tid, "", "Invalidated %v, evicted %v", uuids, res)
+	return res
+}
+
+// uuidsFromIDs accepts plain UUIDs as well as content ids or URLs containing them.
+func uuidsFromIDs(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("No UUIDs to invalidate")
+	}
+	var uuids []string
+	for _, i := range ids {
+		u, err := extractUUIDFromString(i)
+		if err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, u)
+	}
+	return uuids, nil
+}
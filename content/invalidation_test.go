@@ -0,0 +1,90 @@
+package content
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type InvalidatorMock struct {
+	invalidated [][]string
+	evicted     []string
+}
+
+func (im *InvalidatorMock) Invalidate(uuids []string) []string {
+	im.invalidated = append(im.invalidated, uuids)
+	return im.evicted
+}
+
+func TestInvalidate_EvictsFromAllInvalidators(t *testing.T) {
+	first := &InvalidatorMock{evicted: []string{imageSetUUID}}
+	second := &InvalidatorMock{evicted: []string{memberUUID, imageSetUUID}}
+	h := InvalidationHandler{Invalidators: []Invalidator{first, second}}
+
+	body := `{"uuids": ["http://www.ft.com/thing/639cd952-149f-11e7-b0c1-37e417ee6c76"]}`
+	req, err := http.NewRequest(http.MethodPost, "/__invalidate", strings.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.Invalidate).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"evicted": ["639cd952-149f-11e7-2ea7-a07ecd9ac73f", "639cd952-149f-11e7-b0c1-37e417ee6c76"]}`, rr.Body.String())
+	assert.Equal(t, [][]string{{memberUUID}}, first.invalidated)
+	assert.Equal(t, [][]string{{memberUUID}}, second.invalidated)
+}
+
+func TestInvalidate_ReturnsBadRequestForInvalidUUIDs(t *testing.T) {
+	h := InvalidationHandler{}
+	for _, body := range []string{`{"uuids": ["not-a-uuid"]}`, `{"uuids": []}`, `invalid`} {
+		req, err := http.NewRequest(http.MethodPost, "/__invalidate", strings.NewReader(body))
+		assert.NoError(t, err, "Cannot create request necessary for test")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.Invalidate).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
+
+func TestInvalidationWorker_InvalidatesNotifiedContent(t *testing.T) {
+	im := &InvalidatorMock{}
+	q := NewInMemoryQueue(10)
+	w := &InvalidationWorker{Invalidators: []Invalidator{im}, Consumer: q}
+
+	assert.NoError(t, q.SendMessage(Message{Body: `{"contentUri": "http://methode-image-model-mapper.svc.ft.com/image/model/639cd952-149f-11e7-b0c1-37e417ee6c76"}`}))
+	assert.NoError(t, q.SendMessage(Message{Body: `invalid`}))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w.Stop()
+	}()
+	w.Start()
+
+	assert.Equal(t, [][]string{{memberUUID}}, im.invalidated)
+}
+
+func TestResponseCache_InvalidateEvictsResponsesReferencingUUID(t *testing.T) {
+	rc := NewResponseCache(time.Minute, 10)
+	rc.set("first", cachedResponse{uuid: parentUUID, body: []byte("first"), etag: `"1"`}, []string{parentUUID, imageSetUUID, memberUUID}, 0)
+	rc.set("second", cachedResponse{uuid: otherUUID, body: []byte("second"), etag: `"2"`}, []string{otherUUID}, 0)
+
+	assert.Equal(t, []string{parentUUID}, rc.Invalidate([]string{imageSetUUID, memberUUID}), "The evicted responses should be returned")
+
+	_, found := rc.get("first")
+	assert.False(t, found, "Response referencing the invalidated UUID should be evicted")
+	_, found = rc.get("second")
+	assert.True(t, found, "Other responses should be kept")
+}
+
+func TestResponseCache_DoesNotCacheResponsesUnrolledWhileInvalidated(t *testing.T) {
+	rc := NewResponseCache(time.Minute, 10)
+	gen := rc.currentGeneration()
+	rc.Invalidate([]string{imageSetUUID})
+	rc.set("first", cachedResponse{uuid: parentUUID, body: []byte("first"), etag: `"1"`}, []string{parentUUID, imageSetUUID}, gen)
+
+	_, found := rc.get("first")
+	assert.False(t, found, "Response unrolled before an invalidation shouldn't be cached")
+}
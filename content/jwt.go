@@ -0,0 +1,215 @@
+package content
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jwtClockSkew is the difference allowed between the clocks of the token issuer and the service.
+const jwtClockSkew = 30 * time.Second
+
+type JWTAuthenticatorConfig struct {
+	// JWKSFile is the JSON Web Key Set with the RSA keys verifying the tokens.
+	JWKSFile string
+	// Issuer is the iss claim required in the tokens, when set.
+	Issuer string
+	// Audience is the value required in the aud claim of the tokens, when set.
+	Audience string
+	// PermissionsClaim is the claim with the permissions of the caller, either a list or a space separated string.
+	// It's "permissions" when empty.
+	PermissionsClaim string
+}
+
+// JWTAuthenticator authenticates the requests by the RS256 signed JWT bearer token in the Authorization header.
+type JWTAuthenticator struct {
+	config JWTAuthenticatorConfig
+	keys   map[string]*rsa.PublicKey
+	now    func() time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func NewJWTAuthenticator(config JWTAuthenticatorConfig) (*JWTAuthenticator, error) {
+	b, err := ioutil.ReadFile(config.JWKSFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read JWKS from %v", config.JWKSFile)
+	}
+	var set jwks
+	if err = json.Unmarshal(b, &set); err != nil {
+		return nil, errors.Wrapf(err, "Cannot parse JWKS from %v", config.JWKSFile)
+	}
+
+	if config.PermissionsClaim == "" {
+		config.PermissionsClaim = "permissions"
+	}
+	ja := &JWTAuthenticator{config: config, keys: make(map[string]*rsa.PublicKey), now: time.Now}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		pub, err := parseRSAKey(k)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid key %q in %v", k.Kid, config.JWKSFile)
+		}
+		ja.keys[k.Kid] = pub
+	}
+	if len(ja.keys) == 0 {
+		return nil, errors.Errorf("No RSA signing key in %v", config.JWKSFile)
+	}
+	return ja, nil
+}
+
+func parseRSAKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid exponent")
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 2 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("Invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+func (ja *JWTAuthenticator) credential(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+func (ja *JWTAuthenticator) Authenticate(r *http.Request) (string, []string, error) {
+	token := ja.credential(r)
+	if token == "" {
+		return "", nil, errNoCredentials
+	}
+	claims, err := ja.verify(token)
+	if err != nil {
+		return "", nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	return "token subject " + sub, claimPermissions(claims[ja.config.PermissionsClaim]), nil
+}
+
+// verify checks the signature and the time, issuer and audience claims of the token, returning its claims.
+func (ja *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeTokenPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, errors.Errorf("Unsupported token algorithm %q", header.Alg)
+	}
+	key, found := ja.keys[header.Kid]
+	if !found {
+		return nil, errors.Errorf("Unknown token key %q", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("Malformed token signature")
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig); err != nil {
+		return nil, errors.New("Invalid token signature")
+	}
+
+	var claims map[string]interface{}
+	if err = decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := ja.now()
+	exp, hasExp := claims["exp"].(float64)
+	if !hasExp {
+		return nil, errors.New("Token without expiry")
+	}
+	if now.Add(-jwtClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("Expired token")
+	}
+	if nbf, found := claims["nbf"].(float64); found && now.Add(jwtClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("Token not valid yet")
+	}
+	if ja.config.Issuer != "" && claims["iss"] != ja.config.Issuer {
+		return nil, errors.Errorf("Token issued by %v", claims["iss"])
+	}
+	if ja.config.Audience != "" && !hasAudience(claims["aud"], ja.config.Audience) {
+		return nil, errors.Errorf("Token not meant for %v", ja.config.Audience)
+	}
+	return claims, nil
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		return errors.New("Malformed token")
+	}
+	return nil
+}
+
+// hasAudience checks the aud claim, which is either a string or a list of strings.
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, v := range a {
+			if v == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func claimPermissions(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		var permissions []string
+		for _, v := range c {
+			if p, ok := v.(string); ok {
+				permissions = append(permissions, p)
+			}
+		}
+		return permissions
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package content
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	kafkaV1ContentType       = "application/vnd.kafka.v1+json"
+	kafkaBinaryV1ContentType = "application/vnd.kafka.binary.v1+json"
+)
+
+type KafkaProxyConfig struct {
+	Address       string
+	ConsumerGroup string
+	Topic         string
+	BackoffPeriod time.Duration
+}
+
+type kafkaRecord struct {
+	Value string `json:"value"`
+}
+
+// KafkaProxyConsumer consumes FT messages from a topic through the Kafka REST proxy.
+type KafkaProxyConsumer struct {
+	client      *http.Client
+	config      KafkaProxyConfig
+	instanceURI string
+	stop        chan struct{}
+	once        sync.Once
+	// consuming is held while Consume runs, for Stop to wait for it
+	consuming sync.Mutex
+}
+
+func NewKafkaProxyConsumer(config KafkaProxyConfig, client *http.Client) *KafkaProxyConsumer {
+	return &KafkaProxyConsumer{
+		client: client,
+		config: config,
+		stop:   make(chan struct{}),
+	}
+}
+
+func (c *KafkaProxyConsumer) Consume(handler func(Message)) {
+	c.consuming.Lock()
+	defer c.consuming.Unlock()
+	for {
+		select {
+		case <-c.stop:
+			c.destroyInstance()
+			return
+		default:
+		}
+
+		msgs, err := c.poll()
+		if err != nil {
+			logger.Errorf("", "Error consuming from topic %s: %v", c.config.Topic, err)
+			c.destroyInstance()
+		}
+		for _, m := range msgs {
+			handler(m)
+		}
+		// the offsets are committed once the messages are handled, so the ones being handled when the process
+		// dies are consumed again
+		if len(msgs) > 0 {
+			if cErr := c.commitOffsets(); cErr != nil {
+				logger.Errorf("", "Error committing offsets of topic %s: %v", c.config.Topic, cErr)
+				c.destroyInstance()
+			}
+		}
+		if err != nil || len(msgs) == 0 {
+			select {
+			case <-c.stop:
+			case <-time.After(c.config.BackoffPeriod):
+			}
+		}
+	}
+}
+
+// Stop blocks until Consume returns, once the messages already polled are handled and the consumer instance is
+// deleted from the proxy.
+func (c *KafkaProxyConsumer) Stop() {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+	c.consuming.Lock()
+	c.consuming.Unlock()
+}
+
+func (c *KafkaProxyConsumer) poll() ([]Message, error) {
+	if c.instanceURI == "" {
+		if err := c.createInstance(); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.instanceURI+"/topics/"+c.config.Topic, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating request to kafka proxy")
+	}
+	req.Header.Set("Accept", kafkaBinaryV1ContentType)
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []kafkaRecord
+	if err = json.Unmarshal(body, &records); err != nil {
+		return nil, errors.Wrap(err, "Error unmarshalling messages from kafka proxy")
+	}
+
+	var msgs []Message
+	for _, r := range records {
+		raw, err := base64.StdEncoding.DecodeString(r.Value)
+		if err != nil {
+			logger.Errorf("", "Cannot decode message from topic %s: %v", c.config.Topic, err)
+			continue
+		}
+		msgs = append(msgs, parseFTMessage(string(raw)))
+	}
+	return msgs, nil
+}
+
+func (c *KafkaProxyConsumer) createInstance() error {
+	cfg := `{"auto.offset.reset": "largest", "auto.commit.enable": "false"}`
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/consumers/%s", c.config.Address, c.config.ConsumerGroup), bytes.NewReader([]byte(cfg)))
+	if err != nil {
+		return errors.Wrap(err, "Error creating request to kafka proxy")
+	}
+	req.Header.Set("Content-Type", kafkaV1ContentType)
+	body, err := c.do(req)
+	if err != nil {
+		return err
+	}
+
+	var instance struct {
+		BaseURI string `json:"base_uri"`
+	}
+	if err = json.Unmarshal(body, &instance); err != nil {
+		return errors.Wrap(err, "Error unmarshalling consumer instance from kafka proxy")
+	}
+	c.instanceURI = instance.BaseURI
+	return nil
+}
+
+// commitOffsets commits the offsets of the messages polled by the consumer instance.
+func (c *KafkaProxyConsumer) commitOffsets() error {
+	req, err := http.NewRequest(http.MethodPost, c.instanceURI+"/offsets", nil)
+	if err != nil {
+		return errors.Wrap(err, "Error creating request to kafka proxy")
+	}
+	req.Header.Set("Content-Type", kafkaV1ContentType)
+	_, err = c.do(req)
+	return err
+}
+
+func (c *KafkaProxyConsumer) destroyInstance() {
+	if c.instanceURI == "" {
+		return
+	}
+	req, err := http.NewRequest(http.MethodDelete, c.instanceURI, nil)
+	if err != nil {
+		return
+	}
+	if _, err := c.do(req); err != nil {
+		logger.Errorf("", "Error deleting kafka proxy consumer instance: %v", err)
+	}
+	c.instanceURI = ""
+}
+
+func (c *KafkaProxyConsumer) do(req *http.Request) ([]byte, error) {
+	return doKafkaProxyRequest(c.client, req)
+}
+
+// KafkaProxyProducer sends FT messages to a topic through the Kafka REST proxy.
+type KafkaProxyProducer struct {
+	client *http.Client
+	config KafkaProxyConfig
+}
+
+func NewKafkaProxyProducer(config KafkaProxyConfig, client *http.Client) *KafkaProxyProducer {
+	return &KafkaProxyProducer{
+		client: client,
+		config: config,
+	}
+}
+
+func (p *KafkaProxyProducer) SendMessage(m Message) error {
+	value := base64.StdEncoding.EncodeToString([]byte(buildFTMessage(m)))
+	body, err := json.Marshal(map[string][]kafkaRecord{"records": {{Value: value}}})
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling message for kafka proxy")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/topics/%s", p.config.Address, p.config.Topic), bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Error creating request to kafka proxy")
+	}
+	req.Header.Set("Content-Type", kafkaBinaryV1ContentType)
+	_, err = doKafkaProxyRequest(p.client, req)
+	return err
+}
+
+func doKafkaProxyRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	req.Header.Set(userAgent, userAgentValue)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Request to kafka proxy failed")
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading response from kafka proxy")
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, errors.Errorf("Request to kafka proxy failed with status code %d", res.StatusCode)
+	}
+	return body, nil
+}
@@ -0,0 +1,152 @@
+package content
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaProxyConsumer_ConsumesMessages(t *testing.T) {
+	raw := base64.StdEncoding.EncodeToString([]byte("FTMSG/1.0\r\nX-Request-Id: tid_test\r\n\r\n{}"))
+	var mu sync.Mutex
+	polled := false
+	var events []string
+	deleted := make(chan struct{})
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/consumers/content-unroller":
+			assert.Equal(t, kafkaV1ContentType, r.Header.Get("Content-Type"))
+			cfg, _ := ioutil.ReadAll(r.Body)
+			assert.Contains(t, string(cfg), `"auto.commit.enable": "false"`)
+			w.Write([]byte(`{"instance_id": "1", "base_uri": "` + ts.URL + `/consumers/content-unroller/instances/1"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/consumers/content-unroller/instances/1/offsets":
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, "committed")
+		case r.Method == http.MethodGet && r.URL.Path == "/consumers/content-unroller/instances/1/topics/PostPublicationEvents":
+			mu.Lock()
+			defer mu.Unlock()
+			if polled {
+				w.Write([]byte(`[]`))
+				return
+			}
+			polled = true
+			w.Write([]byte(`[{"key": null, "value": "` + raw + `", "partition": 0, "offset": 1}]`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/consumers/content-unroller/instances/1":
+			w.WriteHeader(http.StatusNoContent)
+			close(deleted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewKafkaProxyConsumer(KafkaProxyConfig{
+		Address:       ts.URL,
+		ConsumerGroup: "content-unroller",
+		Topic:         "PostPublicationEvents",
+		BackoffPeriod: 10 * time.Millisecond,
+	}, http.DefaultClient)
+
+	consumed := make(chan Message, 1)
+	go c.Consume(func(m Message) {
+		mu.Lock()
+		events = append(events, "handled")
+		mu.Unlock()
+		consumed <- m
+	})
+
+	select {
+	case m := <-consumed:
+		assert.Equal(t, "tid_test", m.Headers["X-Request-Id"])
+		assert.Equal(t, "{}", m.Body)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Message should be consumed")
+	}
+
+	c.Stop()
+	select {
+	case <-deleted:
+	default:
+		assert.Fail(t, "Consumer instance should be deleted when Stop returns")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"handled", "committed"}, events, "Offsets should be committed once the messages are handled")
+}
+
+func TestKafkaProxyConsumer_DeletesInstanceOnPollError(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(`{"instance_id": "1", "base_uri": "` + ts.URL + `/consumers/content-unroller/instances/1"}`))
+		case http.MethodGet:
+			w.WriteHeader(http.StatusInternalServerError)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewKafkaProxyConsumer(KafkaProxyConfig{
+		Address:       ts.URL,
+		ConsumerGroup: "content-unroller",
+		Topic:         "PostPublicationEvents",
+		BackoffPeriod: time.Hour,
+	}, http.DefaultClient)
+	go c.Consume(func(m Message) {})
+	time.Sleep(100 * time.Millisecond)
+	c.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"POST /consumers/content-unroller",
+		"GET /consumers/content-unroller/instances/1/topics/PostPublicationEvents",
+		"DELETE /consumers/content-unroller/instances/1",
+	}, requests, "The instance failing to poll should be deleted, not leaked")
+}
+
+func TestKafkaProxyProducer_SendsMessage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/UnrolledPublicationEvents", r.URL.Path)
+		assert.Equal(t, kafkaBinaryV1ContentType, r.Header.Get("Content-Type"))
+
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		var records map[string][]kafkaRecord
+		assert.NoError(t, json.Unmarshal(body, &records))
+		raw, err := base64.StdEncoding.DecodeString(records["records"][0].Value)
+		assert.NoError(t, err)
+		assert.Equal(t, "FTMSG/1.0\r\nX-Request-Id: tid_test\r\n\r\n{}", string(raw))
+	}))
+	defer ts.Close()
+
+	p := NewKafkaProxyProducer(KafkaProxyConfig{Address: ts.URL, Topic: "UnrolledPublicationEvents"}, http.DefaultClient)
+	err := p.SendMessage(Message{Headers: map[string]string{"X-Request-Id": "tid_test"}, Body: "{}"})
+	assert.NoError(t, err)
+}
+
+func TestKafkaProxyProducer_ReturnsErrorOnFailure(t *testing.T) {
+	ts := errorContentServerMock(t, http.StatusInternalServerError)
+	defer ts.Close()
+
+	p := NewKafkaProxyProducer(KafkaProxyConfig{Address: ts.URL, Topic: "UnrolledPublicationEvents"}, http.DefaultClient)
+	assert.Error(t, p.SendMessage(Message{Body: "{}"}))
+}
@@ -0,0 +1,48 @@
+package content
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/sirupsen/logrus"
+)
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// GetLogLevelHandler writes the current log level, e.g. {"level": "info"}.
+func GetLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	writeLogLevel(w)
+}
+
+// SetLogLevelHandler changes the log level to the one supplied in the body, e.g. {"level": "debug"}.
+func SetLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	tid := transactionidutils.GetTransactionIDFromRequest(r)
+
+	var req logLevel
+	b, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(b, &req)
+	}
+	var level logrus.Level
+	if err == nil {
+		level, err = logrus.ParseLevel(req.Level)
+	}
+	if err != nil {
+		logger.Errorf(tid, "Invalid log level request: %v", err)
+		writeErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	SetLogLevel(level)
+	logger.Infof(tid, "", "Log level changed to %v", level)
+	writeLogLevel(w)
+}
+
+func writeLogLevel(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(logLevel{Level: GetLogLevel().String()})
+}
@@ -0,0 +1,88 @@
+package content
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLogLevelHandler(t *testing.T) {
+	defer SetLogLevel(logrus.InfoLevel)
+
+	rr := httptest.NewRecorder()
+	SetLogLevelHandler(rr, httptest.NewRequest(http.MethodPut, "/__log-level", strings.NewReader(`{"level": "debug"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"level": "debug"}`, rr.Body.String())
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel(), "The level of the main logger should change too")
+
+	rr = httptest.NewRecorder()
+	GetLogLevelHandler(rr, httptest.NewRequest(http.MethodGet, "/__log-level", nil))
+	assert.JSONEq(t, `{"level": "debug"}`, rr.Body.String())
+}
+
+func TestSetLogLevelHandler_InvalidLevel(t *testing.T) {
+	rr := httptest.NewRecorder()
+	SetLogLevelHandler(rr, httptest.NewRequest(http.MethodPut, "/__log-level", strings.NewReader(`{"level": "verbose"}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, logrus.InfoLevel, GetLogLevel())
+}
+
+func TestDebugLogHeader_EnablesDebugLogsOfTransaction(t *testing.T) {
+	var buf bytes.Buffer
+	out := logger.log.Out
+	logger.log.Out, logger.debugLog.Out = &buf, &buf
+	defer func() { logger.log.Out, logger.debugLog.Out = out, out }()
+
+	var a *Auth
+	r := mux.NewRouter()
+	r.Use(AccessLog, a.DebugLog)
+	r.HandleFunc("/content", func(w http.ResponseWriter, r *http.Request) {
+		logger.Debugf(r.Header.Get("X-Request-Id"), "", "debug details")
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/content", nil))
+	assert.NotContains(t, buf.String(), "debug details", "Debug logs should be disabled by default")
+
+	req := httptest.NewRequest(http.MethodPost, "/content", nil)
+	req.Header.Set(DebugLogHeader, "true")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buf.String(), "debug details")
+
+	logger.Debugf(req.Header.Get("X-Request-Id"), "", "after the request")
+	assert.NotContains(t, buf.String(), "after the request", "Debug logs should only be enabled while the request is served")
+}
+
+func TestDebugLogHeader_NeedsAdminPermission(t *testing.T) {
+	var buf bytes.Buffer
+	out := logger.log.Out
+	logger.log.Out, logger.debugLog.Out = &buf, &buf
+	defer func() { logger.log.Out, logger.debugLog.Out = out, out }()
+
+	a := &Auth{Authenticators: []Authenticator{authenticatorFunc(func(r *http.Request) (string, []string, error) {
+		if r.Header.Get("X-Api-Key") == "admin-secret" {
+			return "API key ops", []string{PermissionAdmin}, nil
+		}
+		return "API key next", []string{PermissionContent}, nil
+	})}}
+	r := mux.NewRouter()
+	r.Use(AccessLog, a.DebugLog)
+	r.HandleFunc("/content", func(w http.ResponseWriter, r *http.Request) {
+		logger.Debugf(r.Header.Get("X-Request-Id"), "", "debug details")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/content", nil)
+	req.Header.Set(DebugLogHeader, "true")
+	req.Header.Set("X-Api-Key", "next-secret")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.NotContains(t, buf.String(), "debug details", "Debug logs should need the admin permission")
+
+	req.Header.Set("X-Api-Key", "admin-secret")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buf.String(), "debug details")
+}
@@ -0,0 +1,148 @@
+package content
+
+import (
+	"net/http"
+)
+
+// formatParam is the query parameter selecting the format of the unrolled content, e.g. "?format=normalised".
+const formatParam = "format"
+
+const (
+	nestedFormat     = "nested"
+	normalisedFormat = "normalised"
+	includedField    = "included"
+)
+
+// parseNormalised tells whether the request asks for the normalised format. The nested format is the default.
+func parseNormalised(r *http.Request) (bool, error) {
+	switch f := r.URL.Query().Get(formatParam); f {
+	case "", nestedFormat:
+		return false, nil
+	case normalisedFormat:
+		return true, nil
+	default:
+		return false, requestError{http.StatusBadRequest, "Unknown format " + f}
+	}
+}
+
+// normalise returns the unrolled content with every model the unroller expanded in it (images, image set members,
+// dynamic content and related content) replaced by a reference holding its id, the models being held once in the
+// included map keyed by UUID. The models nested in the included ones are normalised as well. The concepts are left
+// in place, as their entries also hold the relationship to the content, e.g. the predicate of an annotation.
+// The unrolled content isn't modified, as it shares cached models.
+func normalise(uc Content) Content {
+	included := make(map[string]interface{})
+	n := Content(normaliseModel(uc, included))
+	n[includedField] = included
+	return n
+}
+
+// normaliseModel returns a copy of the model with the models expanded in it replaced by references.
+func normaliseModel(m map[string]interface{}, included map[string]interface{}) map[string]interface{} {
+	n := make(map[string]interface{}, len(m)+1)
+	for k, v := range m {
+		n[k] = v
+	}
+	for _, f := range []string{mainImage, storyPackage} {
+		if v, found := m[f]; found {
+			n[f] = include(v, included)
+		}
+	}
+	for _, f := range []string{embeds, members, containedIn, contains} {
+		if list, ok := asList(m[f]); ok {
+			refs := make([]interface{}, len(list))
+			for i, v := range list {
+				refs[i] = include(v, included)
+			}
+			n[f] = refs
+		}
+	}
+	if alt, ok := asMap(m[altImages]); ok {
+		refs := make(map[string]interface{}, len(alt))
+		for k, v := range alt {
+			refs[k] = include(v, included)
+		}
+		n[altImages] = refs
+	}
+	// the lead image entries hold the relationship to their image, which is the model
+	if list, ok := asList(m[leadImages]); ok {
+		entries := make([]interface{}, len(list))
+		for i, v := range list {
+			entries[i] = v
+			if e, ok := asMap(v); ok {
+				ref := make(map[string]interface{}, len(e))
+				for k, f := range e {
+					ref[k] = f
+				}
+				if img, found := e[image]; found {
+					ref[image] = include(img, included)
+				}
+				entries[i] = ref
+			}
+		}
+		n[leadImages] = entries
+	}
+	return n
+}
+
+// include holds the expanded model in the included map and returns the reference replacing it. The references left
+// unexpanded, without a type, are returned as they are.
+func include(v interface{}, included map[string]interface{}) interface{} {
+	m, ok := asMap(v)
+	if !ok {
+		return v
+	}
+	uuid, expanded := modelUUID(m)
+	if !expanded {
+		return v
+	}
+	model := normaliseModel(m, included)
+	// a model can be expanded differently in different places, e.g. as a related content summary
+	if prev, found := included[uuid].(map[string]interface{}); found {
+		for k, f := range model {
+			if _, set := prev[k]; !set {
+				prev[k] = f
+			}
+		}
+	} else {
+		included[uuid] = model
+	}
+	return map[string]interface{}{id: m[id]}
+}
+
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch t := v.(type) {
+	case Content:
+		return t, true
+	case map[string]interface{}:
+		return t, true
+	}
+	return nil, false
+}
+
+func asList(v interface{}) ([]interface{}, bool) {
+	switch t := v.(type) {
+	case []interface{}:
+		return t, true
+	case []Content:
+		list := make([]interface{}, len(t))
+		for i, c := range t {
+			list[i] = c
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// modelUUID returns the UUID of an expanded model. The references left unexpanded have no type.
+func modelUUID(m map[string]interface{}) (string, bool) {
+	if t, _ := m["type"].(string); t == "" {
+		return "", false
+	}
+	mID, _ := m[id].(string)
+	uuid, err := extractUUIDFromString(mID)
+	if err != nil {
+		return "", false
+	}
+	return uuid, true
+}
@@ -0,0 +1,80 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func unrolledWithSharedImageSet() Content {
+	member := Content{id: "http://www.ft.com/thing/" + memberUUID, "type": "http://www.ft.com/ontology/content/MediaResource", "binaryUrl": "http://image-storage-location"}
+	imageSet := func() Content {
+		return Content{id: "http://www.ft.com/thing/" + imageSetUUID, "type": ImageSetType, members: []interface{}{member}}
+	}
+	return Content{
+		id:        "http://www.ft.com/thing/" + parentUUID,
+		"type":    "http://www.ft.com/ontology/content/Article",
+		mainImage: imageSet(),
+		embeds:    []Content{imageSet(), {id: "http://www.ft.com/thing/" + otherUUID}},
+		altImages: map[string]interface{}{promotionalImage: imageSet()},
+		"brands":  []interface{}{map[string]interface{}{id: "http://api.ft.com/things/" + brandUUID}},
+		"annotations": []interface{}{
+			map[string]interface{}{id: "http://api.ft.com/things/" + personUUID, "predicate": "http://www.ft.com/ontology/annotation/about", "type": "http://www.ft.com/ontology/person/Person", prefLabel: "Jane Doe"},
+			map[string]interface{}{id: "http://api.ft.com/things/" + personUUID, "predicate": "http://www.ft.com/ontology/annotation/mentions", "type": "http://www.ft.com/ontology/person/Person", prefLabel: "Jane Doe"},
+		},
+	}
+}
+
+func TestNormalise_HoldsEveryModelOnce(t *testing.T) {
+	uc := unrolledWithSharedImageSet()
+	n := normalise(uc)
+
+	imageSetRef := map[string]interface{}{id: "http://www.ft.com/thing/" + imageSetUUID}
+	assert.Equal(t, imageSetRef, n[mainImage])
+	assert.Equal(t, []interface{}{imageSetRef, Content{id: "http://www.ft.com/thing/" + otherUUID}}, n[embeds])
+	assert.Equal(t, map[string]interface{}{promotionalImage: imageSetRef}, n[altImages])
+	assert.Equal(t, uc["brands"], n["brands"], "References left unexpanded should be kept as they are")
+	assert.Equal(t, uc["annotations"], n["annotations"], "Concepts should be kept in place with their predicates")
+	assert.Equal(t, uc[id], n[id], "The article should stay at the top level")
+
+	included := n[includedField].(map[string]interface{})
+	assert.Len(t, included, 2)
+	assert.Equal(t, []interface{}{map[string]interface{}{id: "http://www.ft.com/thing/" + memberUUID}}, included[imageSetUUID].(map[string]interface{})[members])
+	assert.Equal(t, "http://image-storage-location", included[memberUUID].(map[string]interface{})["binaryUrl"])
+
+	assert.Equal(t, ImageSetType, uc[mainImage].(Content)["type"])
+	assert.Len(t, uc[mainImage].(Content)[members].([]interface{})[0].(Content), 3, "The unrolled content shouldn't be modified")
+}
+
+func TestGetContent_NormalisedFormat(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{unrolledWithSharedImageSet(), nil, nil}
+		},
+	}
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	req := httptest.NewRequest(http.MethodPost, "/content?format=normalised", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var res map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	assert.Contains(t, res[includedField], imageSetUUID)
+	assert.Equal(t, map[string]interface{}{id: "http://www.ft.com/thing/" + imageSetUUID}, res[mainImage])
+
+	req = httptest.NewRequest(http.MethodPost, "/content?format=flat", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Unknown format flat", rr.Body.String())
+}
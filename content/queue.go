@@ -0,0 +1,123 @@
+package content
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const ftMessageVersion = "FTMSG/1.0"
+
+// Message is a message consumed from or produced to a queue.
+type Message struct {
+	Headers map[string]string
+	Body    string
+}
+
+// MessageConsumer reads messages from a queue.
+type MessageConsumer interface {
+	// Consume passes every consumed message to the handler and blocks until Stop is called.
+	Consume(handler func(Message))
+	// Stop blocks until Consume returns, so that the message being handled isn't lost.
+	Stop()
+}
+
+// MessageProducer writes messages to a queue.
+type MessageProducer interface {
+	SendMessage(Message) error
+}
+
+// parseFTMessage reads a message in the FT message format: a version line, header lines and the body,
+// separated from the headers by an empty line. Anything else is treated as a body without headers.
+func parseFTMessage(raw string) Message {
+	m := Message{Headers: make(map[string]string)}
+	if !strings.HasPrefix(raw, ftMessageVersion) {
+		m.Body = raw
+		return m
+	}
+
+	parts := strings.SplitN(raw, "\r\n\r\n", 2)
+	if len(parts) == 2 {
+		m.Body = parts[1]
+	}
+	for _, line := range strings.Split(parts[0], "\r\n")[1:] {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		m.Headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return m
+}
+
+// buildFTMessage writes the message in the FT message format.
+func buildFTMessage(m Message) string {
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(ftMessageVersion + "\r\n")
+	for _, k := range keys {
+		b.WriteString(k + ": " + m.Headers[k] + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.WriteString(m.Body)
+	return b.String()
+}
+
+// InMemoryQueue is a MessageConsumer and MessageProducer backed by a channel, used in tests and local runs.
+type InMemoryQueue struct {
+	messages chan Message
+	stop     chan struct{}
+	once     sync.Once
+	// consuming is held while Consume runs, for Stop to wait for it
+	consuming sync.Mutex
+}
+
+func NewInMemoryQueue(capacity int) *InMemoryQueue {
+	return &InMemoryQueue{
+		messages: make(chan Message, capacity),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (q *InMemoryQueue) SendMessage(m Message) error {
+	select {
+	case <-q.stop:
+		return errors.New("Queue is stopped")
+	default:
+	}
+
+	select {
+	case q.messages <- m:
+		return nil
+	case <-q.stop:
+		return errors.New("Queue is stopped")
+	}
+}
+
+func (q *InMemoryQueue) Consume(handler func(Message)) {
+	q.consuming.Lock()
+	defer q.consuming.Unlock()
+	for {
+		select {
+		case m := <-q.messages:
+			handler(m)
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+func (q *InMemoryQueue) Stop() {
+	q.once.Do(func() {
+		close(q.stop)
+	})
+	q.consuming.Lock()
+	q.consuming.Unlock()
+}
@@ -0,0 +1,226 @@
+package content
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+)
+
+const (
+	originSystemIDHeader = "X-Origin-System-Id"
+	// bucketSweepInterval is how often the buckets of the clients that stopped calling are dropped.
+	bucketSweepInterval = time.Minute
+	// maxBuckets bounds the memory taken by the buckets. Once reached, the new clients share overflowClient's bucket
+	// until the sweep drops some.
+	maxBuckets     = 10000
+	overflowClient = "overflow"
+)
+
+type RateLimiterConfig struct {
+	// Rate is the number of requests per second allowed to every client on average. No limit per client when 0.
+	Rate float64
+	// Burst is the number of requests a client can make at once, above the rate.
+	Burst int
+	// MaxInFlight is the number of requests served at once across all the clients. No limit when 0.
+	MaxInFlight int
+	// ClientHeaders identifies the unauthenticated clients by their API key in APIKeyHeader, then by
+	// X-Origin-System-Id, before their IP. The clients can get around the limit by changing them.
+	ClientHeaders bool
+	APIKeyHeader  string
+}
+
+type RateLimiterMetrics struct {
+	RateLimited         int64 `json:"rateLimited"`
+	InFlightLimited     int64 `json:"inFlightLimited"`
+	AuthFailuresLimited int64 `json:"authFailuresLimited"`
+}
+
+// RateLimiter rejects the requests of the clients over their rate, using a token bucket per client, and the requests
+// over the in-flight limit. A nil *RateLimiter is valid and limits nothing.
+type RateLimiter struct {
+	sync.Mutex
+	config    RateLimiterConfig
+	metrics   RateLimiterMetrics
+	buckets   map[string]*bucket
+	inFlight  chan struct{}
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	rl := &RateLimiter{
+		config:  config,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	if config.MaxInFlight > 0 {
+		rl.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+	return rl
+}
+
+func (rl *RateLimiter) Metrics() RateLimiterMetrics {
+	return RateLimiterMetrics{
+		RateLimited:         atomic.LoadInt64(&rl.metrics.RateLimited),
+		InFlightLimited:     atomic.LoadInt64(&rl.metrics.InFlightLimited),
+		AuthFailuresLimited: atomic.LoadInt64(&rl.metrics.AuthFailuresLimited),
+	}
+}
+
+// Limit is a middleware answering 429 with Retry-After to the requests over the limits. It goes after Auth.Require,
+// so that the clients are identified by their authenticated caller.
+func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
+	if rl == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tid := transactionidutils.GetTransactionIDFromRequest(r)
+
+		client, kind := rl.clientOf(r)
+		if wait, allowed := rl.take(client); !allowed {
+			atomic.AddInt64(&rl.metrics.RateLimited, 1)
+			logger.Debugf(tid, "", "Client identified by %s over the rate limit, retry in %v", kind, wait)
+			tooManyRequests(w, wait, "Rate limit exceeded")
+			return
+		}
+
+		if rl.inFlight != nil {
+			select {
+			case rl.inFlight <- struct{}{}:
+				defer func() { <-rl.inFlight }()
+			default:
+				atomic.AddInt64(&rl.metrics.InFlightLimited, 1)
+				logger.Debugf(tid, "", "Over the limit of %d requests in flight", rl.config.MaxInFlight)
+				tooManyRequests(w, time.Second, "Too many requests in flight")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientOf returns the identity of the client making the request, and what it's based on. The clients are identified
+// by the caller authenticated by Auth.Require, otherwise by their headers when ClientHeaders is set, then by their IP.
+// Behind the load balancer, the IP is the one of the ingress, so it's shared by all the clients.
+func (rl *RateLimiter) clientOf(r *http.Request) (string, string) {
+	if caller, found := callerFrom(r.Context()); found {
+		return "caller:" + caller, "authenticated caller"
+	}
+	if rl.config.ClientHeaders {
+		if rl.config.APIKeyHeader != "" {
+			if key := r.Header.Get(rl.config.APIKeyHeader); key != "" {
+				return "key:" + key, "API key"
+			}
+		}
+		if system := r.Header.Get(originSystemIDHeader); system != "" {
+			return "system:" + system, originSystemIDHeader
+		}
+	}
+	return "ip:" + remoteIP(r), "IP"
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// takeAuthFailure takes a failed attempt from the credentials. Once they ran out of attempts, it returns how long
+// they're refused for. Failed attempts are limited at the same rate as the requests.
+func (rl *RateLimiter) takeAuthFailure(credentials string) (time.Duration, bool) {
+	if rl == nil {
+		return 0, true
+	}
+	wait, allowed := rl.take("auth-failure:" + credentials)
+	if !allowed {
+		atomic.AddInt64(&rl.metrics.AuthFailuresLimited, 1)
+	}
+	return wait, allowed
+}
+
+type callerKey struct{}
+
+func withCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// callerFrom returns the caller authenticated by Auth.Require.
+func callerFrom(ctx context.Context) (string, bool) {
+	caller, found := ctx.Value(callerKey{}).(string)
+	return caller, found
+}
+
+// take takes a token from the bucket of the client. Otherwise it returns how long the client has to wait for one.
+func (rl *RateLimiter) take(client string) (time.Duration, bool) {
+	if rl.config.Rate <= 0 {
+		return 0, true
+	}
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+	b, found := rl.buckets[client]
+	if !found && len(rl.buckets) >= maxBuckets {
+		rl.lastSweep = time.Time{}
+		rl.sweep(now)
+		if len(rl.buckets) >= maxBuckets {
+			client = overflowClient
+			b, found = rl.buckets[client]
+		}
+	}
+	if !found {
+		b = &bucket{tokens: float64(rl.config.Burst), updated: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = rl.refill(b, now)
+	b.updated = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rl.config.Rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+func (rl *RateLimiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(float64(rl.config.Burst), b.tokens+now.Sub(b.updated).Seconds()*rl.config.Rate)
+}
+
+// sweep drops the buckets refilled since, as they're the same as new ones.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < bucketSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+	for client, b := range rl.buckets {
+		if rl.refill(b, now) >= float64(rl.config.Burst) {
+			delete(rl.buckets, client)
+		}
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeErrorMessage(w, msg, http.StatusTooManyRequests)
+}
@@ -0,0 +1,155 @@
+package content
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rateLimitedRequest(h http.Handler, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/content", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func requestFrom(h http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/content", nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+type authenticatorFunc func(r *http.Request) (string, []string, error)
+
+func (f authenticatorFunc) Authenticate(r *http.Request) (string, []string, error) {
+	return f(r)
+}
+
+func TestRateLimiter_LimitsEveryClientSeparately(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 2})
+	rl.now = func() time.Time { return now }
+	h := rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	client := "10.0.0.1:1234"
+	assert.Equal(t, http.StatusOK, requestFrom(h, client).Code)
+	assert.Equal(t, http.StatusOK, requestFrom(h, client).Code)
+	w := requestFrom(h, client)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Rate limit exceeded")
+
+	assert.Equal(t, http.StatusOK, requestFrom(h, "10.0.0.2:1234").Code, "Another IP should have its own bucket")
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, requestFrom(h, client).Code, "The bucket should be refilled at the rate")
+	assert.Equal(t, http.StatusTooManyRequests, requestFrom(h, client).Code)
+	assert.Equal(t, int64(2), rl.Metrics().RateLimited)
+}
+
+func TestRateLimiter_IdentifiesClientsByTheirHeaders(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 1, ClientHeaders: true, APIKeyHeader: "X-Api-Key"})
+	h := rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	key := map[string]string{"X-Api-Key": "key-1", originSystemIDHeader: "cct"}
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(h, key).Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(h, key).Code)
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(h, map[string]string{"X-Api-Key": "key-2"}).Code, "Another API key should have its own bucket")
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(h, map[string]string{originSystemIDHeader: "cct"}).Code, "The origin system should have its own bucket")
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(h, nil).Code, "The IP should have its own bucket")
+}
+
+func TestRateLimiter_IgnoresClientHeaders(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 1})
+	h := rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(h, map[string]string{"X-Api-Key": "key-1", "X-Origin-System-Id": "cct"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(h, map[string]string{"X-Api-Key": "key-2", "X-Origin-System-Id": "spark"}).Code)
+	assert.Len(t, rl.buckets, 1)
+}
+
+func TestRateLimiter_CapsBuckets(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 1})
+	rl.now = func() time.Time { return now }
+	for i := 0; i < maxBuckets; i++ {
+		_, allowed := rl.take(fmt.Sprintf("ip:%d", i))
+		assert.True(t, allowed)
+	}
+
+	_, allowed := rl.take("ip:new")
+	assert.True(t, allowed)
+	_, allowed = rl.take("ip:other")
+	assert.False(t, allowed, "The clients over the cap should share a bucket")
+	assert.Len(t, rl.buckets, maxBuckets+1)
+
+	now = now.Add(time.Second)
+	_, allowed = rl.take("ip:new")
+	assert.True(t, allowed)
+	assert.Contains(t, rl.buckets, "ip:new", "The refilled buckets should be dropped to make room")
+}
+
+func TestRateLimiter_IdentifiesAuthenticatedCaller(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 1})
+	a := &Auth{Authenticators: []Authenticator{authenticatorFunc(func(r *http.Request) (string, []string, error) {
+		return "API key next", []string{PermissionContent}, nil
+	})}}
+	h := a.Require(PermissionContent, rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(h, map[string]string{"X-Api-Key": "key-1"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(h, map[string]string{"X-Api-Key": "key-2"}).Code, "The caller should be limited whatever its headers")
+	assert.Contains(t, rl.buckets, "caller:API key next")
+}
+
+func TestRateLimiter_DropsRefilledBuckets(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 5})
+	rl.now = func() time.Time { return now }
+
+	_, allowed := rl.take("ip:10.0.0.1")
+	assert.True(t, allowed)
+	now = now.Add(bucketSweepInterval)
+	rl.take("ip:10.0.0.2")
+	assert.NotContains(t, rl.buckets, "ip:10.0.0.1")
+	assert.Contains(t, rl.buckets, "ip:10.0.0.2")
+}
+
+func TestRateLimiter_LimitsRequestsInFlight(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{MaxInFlight: 1})
+	started, release := make(chan struct{}), make(chan struct{})
+	h := rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan int)
+	go func() {
+		done <- rateLimitedRequest(h, nil).Code
+	}()
+	<-started
+
+	w := rateLimitedRequest(h, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), rl.Metrics().InFlightLimited)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func TestRateLimiter_NilLimitsNothing(t *testing.T) {
+	var rl *RateLimiter
+	h := rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(h, nil).Code)
+	}
+}
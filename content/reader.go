@@ -1,10 +1,12 @@
 package content
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
 	uuidutils "github.com/Financial-Times/uuid-utils-go"
@@ -17,37 +19,75 @@ const (
 )
 
 type Reader interface {
-	Get([]string, string) (map[string]Content, error)
-	GetInternal([]string, string) (map[string]Content, error)
+	Get(context.Context, []string, string) (map[string]Content, error)
+	GetInternal(context.Context, []string, string) (map[string]Content, error)
 }
 
-type ReaderFunc func([]string, string) (map[string]Content, error)
+type ReaderFunc func(context.Context, []string, string) (map[string]Content, error)
+
+// membersFetchReserve is the part of the remaining request budget kept from the first fetch in Get for fetching
+// the members of the image sets found. It's at most half the time left, so that short budgets are shared evenly.
+const membersFetchReserve = 500 * time.Millisecond
 
 type ReaderConfig struct {
 	ContentStoreAppName         string
 	ContentStoreHost            string
 	ContentPathEndpoint         string
 	InternalContentPathEndpoint string
+	// InternalContentClient overrides the client of the internalcontent reads, e.g. for a different timeout.
+	InternalContentClient *http.Client
+	// RecordDir is where every backend request and its response are written to when set, for replaying them
+	// later with a replay reader.
+	RecordDir string
+}
+
+// ReaderMetrics counts the backend lookups made by a ContentReader.
+type ReaderMetrics struct {
+	RequestedUUIDs int64 `json:"requestedUUIDs"`
+	BackendCalls   int64 `json:"backendCalls"`
+	CoalescedUUIDs int64 `json:"coalescedUUIDs"`
+	CoalescedCalls int64 `json:"coalescedCalls"`
 }
 
 type ContentReader struct {
-	client *http.Client
-	config ReaderConfig
+	metrics        ReaderMetrics
+	client         *http.Client
+	internalClient *http.Client
+	config         ReaderConfig
+	flights        *flightGroup
 }
 
 func NewContentReader(rConfig ReaderConfig, client *http.Client) *ContentReader {
+	internalClient := client
+	if rConfig.InternalContentClient != nil {
+		internalClient = rConfig.InternalContentClient
+	}
 	return &ContentReader{
-		client: client,
-		config: rConfig,
+		client:         client,
+		internalClient: internalClient,
+		config:         rConfig,
+		flights:        newFlightGroup(),
+	}
+}
+
+// Metrics returns a snapshot of the reader's lookup counters.
+func (cr *ContentReader) Metrics() ReaderMetrics {
+	return ReaderMetrics{
+		RequestedUUIDs: atomic.LoadInt64(&cr.metrics.RequestedUUIDs),
+		BackendCalls:   atomic.LoadInt64(&cr.metrics.BackendCalls),
+		CoalescedUUIDs: atomic.LoadInt64(&cr.metrics.CoalescedUUIDs),
+		CoalescedCalls: atomic.LoadInt64(&cr.metrics.CoalescedCalls),
 	}
 }
 
 // Get reads content from content-public-read
-func (cr *ContentReader) Get(uuids []string, tid string) (map[string]Content, error) {
+func (cr *ContentReader) Get(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
 	var cm = make(map[string]Content)
 	requestURL := fmt.Sprintf("%s%s", cr.config.ContentStoreHost, cr.config.ContentPathEndpoint)
 
-	contentBatch, err := cr.doGet(uuids, tid, requestURL, cr.config.ContentStoreAppName)
+	firstCtx, cancel := withBudgetReserve(ctx, membersFetchReserve)
+	contentBatch, err := cr.doGetCoalesced(firstCtx, cr.client, uuids, tid, requestURL, cr.config.ContentStoreAppName)
+	cancel()
 	if err != nil {
 		return cm, err
 	}
@@ -64,7 +104,7 @@ func (cr *ContentReader) Get(uuids []string, tid string) (map[string]Content, er
 		return cm, nil
 	}
 
-	imgModelsList, err := cr.doGet(imgModelUUIDs, tid, requestURL, cr.config.ContentStoreAppName)
+	imgModelsList, err := cr.doGetCoalesced(ctx, cr.client, imgModelUUIDs, tid, requestURL, cr.config.ContentStoreAppName)
 	if err != nil {
 		return cm, err
 	}
@@ -77,11 +117,11 @@ func (cr *ContentReader) Get(uuids []string, tid string) (map[string]Content, er
 }
 
 // GetInternal reads internal components from content-public-read
-func (cr *ContentReader) GetInternal(uuids []string, tid string) (map[string]Content, error) {
+func (cr *ContentReader) GetInternal(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
 	var cm = make(map[string]Content)
 	requestURL := fmt.Sprintf("%s%s", cr.config.ContentStoreHost, cr.config.InternalContentPathEndpoint)
 
-	internalContent, err := cr.doGet(uuids, tid, requestURL, cr.config.ContentStoreAppName)
+	internalContent, err := cr.doGetCoalesced(ctx, cr.internalClient, uuids, tid, requestURL, cr.config.ContentStoreAppName)
 	if err != nil {
 		return cm, err
 	}
@@ -93,16 +133,95 @@ func (cr *ContentReader) GetInternal(uuids []string, tid string) (map[string]Con
 	return cm, nil
 }
 
-func (cr *ContentReader) doGet(uuids []string, tid string, reqURL string, appName string) ([]Content, error) {
+// doGetCoalesced requests the given UUIDs from the backend. UUIDs already being requested by concurrent callers
+// are not requested again; the caller waits for the in-flight lookup and shares its result instead.
+func (cr *ContentReader) doGetCoalesced(ctx context.Context, client *http.Client, uuids []string, tid string, reqURL string, appName string) ([]Content, error) {
+	var validUUIDs []string
+	for _, uuid := range uuids {
+		if err := uuidutils.ValidateUUID(uuid); err == nil {
+			validUUIDs = append(validUUIDs, uuid)
+		}
+	}
+
+	owned, shared := cr.flights.join(reqURL, validUUIDs)
+	atomic.AddInt64(&cr.metrics.RequestedUUIDs, int64(len(owned)+len(shared)))
+	atomic.AddInt64(&cr.metrics.CoalescedUUIDs, int64(len(shared)))
+	if len(owned) == 0 && len(shared) > 0 {
+		atomic.AddInt64(&cr.metrics.CoalescedCalls, 1)
+	}
+
 	var cb []Content
+	if len(owned) > 0 {
+		var ownedUUIDs []string
+		seen := make(map[string]bool)
+		for _, uuid := range validUUIDs {
+			if _, found := owned[uuid]; found && !seen[uuid] {
+				ownedUUIDs = append(ownedUUIDs, uuid)
+				seen[uuid] = true
+			}
+		}
+		var err error
+		cb, err = cr.getOwned(ctx, client, ownedUUIDs, owned, tid, reqURL, appName)
+		if err != nil {
+			return cb, err
+		}
+	}
 
-	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	var retry []string
+	for uuid, call := range shared {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return cb, errors.Wrapf(ctx.Err(), "Request to %v cancelled", appName)
+		}
+		if call.cancelled {
+			// the caller owning the lookup went away, but this one is still waiting for the result
+			retry = append(retry, uuid)
+			continue
+		}
+		if call.err != nil {
+			return cb, call.err
+		}
+		if call.found {
+			cb = append(cb, call.c.clone())
+		}
+	}
+
+	if len(retry) > 0 {
+		retried, err := cr.doGet(ctx, client, retry, tid, reqURL, appName)
+		if err != nil {
+			return cb, err
+		}
+		cb = append(cb, retried...)
+	}
+	return cb, nil
+}
+
+// getOwned reads the UUIDs of the owned calls and publishes the result to the callers waiting on them. A panic is
+// published as an error before going on, otherwise the waiters would be stuck until their context ends.
+func (cr *ContentReader) getOwned(ctx context.Context, client *http.Client, uuids []string, owned map[string]*flightCall, tid string, reqURL string, appName string) (cb []Content, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			cr.flights.complete(reqURL, owned, nil, errors.Errorf("Request to %v panicked: %v", appName, p), false)
+			panic(p)
+		}
+		cr.flights.complete(reqURL, owned, cb, err, err != nil && ctx.Err() != nil)
+	}()
+	return cr.doGet(ctx, client, uuids, tid, reqURL, appName)
+}
+
+func (cr *ContentReader) doGet(ctx context.Context, client *http.Client, uuids []string, tid string, reqURL string, appName string) ([]Content, error) {
+	var cb []Content
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
 		return cb, errors.Wrapf(err, "Error creating request to %v", appName)
 	}
 
 	req.Header.Add(transactionidutils.TransactionIDHeader, tid)
 	req.Header.Set(userAgent, userAgentValue)
+	// set explicitly, the transport doesn't decompress the response, so the encodings registered are accepted too
+	req.Header.Set("Accept-Encoding", acceptedEncodings())
 	q := req.URL.Query()
 	for _, uuid := range uuids {
 		if err = uuidutils.ValidateUUID(uuid); err == nil {
@@ -110,28 +229,48 @@ func (cr *ContentReader) doGet(uuids []string, tid string, reqURL string, appNam
 		}
 	}
 	req.URL.RawQuery = q.Encode()
-	res, err := cr.client.Do(req)
+	atomic.AddInt64(&cr.metrics.BackendCalls, 1)
+	requestStatsFrom(ctx).addBackendCall()
+	res, err := client.Do(req)
 	if err != nil {
 		return cb, errors.Wrapf(err, "Request to %v failed.", appName)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
+		cr.record(tid, reqURL, uuids, res.StatusCode, nil)
 		return cb, errors.Errorf("Request to %v failed with status code %d", appName, res.StatusCode)
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	body, err := decodeBody(res.Header.Get("Content-Encoding"), res.Body)
 	if err != nil {
-		return cb, errors.Wrapf(err, "Error reading response received from %v", appName)
+		// not wrapped, the decoding failures are request errors only for the bodies sent by the clients
+		return cb, errors.Errorf("Error reading response received from %v: %v", appName, err)
 	}
+	cr.record(tid, reqURL, uuids, res.StatusCode, body)
 
 	err = json.Unmarshal(body, &cb)
 	if err != nil {
 		return cb, errors.Wrapf(err, "Error unmarshalling response from %v", appName)
 	}
+	logger.Debugf(tid, "", "Requested %v from %v, resolved %v", uuids, appName, contentIDs(cb))
 	return cb, nil
 }
 
+// withBudgetReserve returns a context whose deadline leaves the reserve, up to half the time left, before the
+// deadline of ctx.
+func withBudgetReserve(ctx context.Context, reserve time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	budget := time.Until(deadline)
+	if reserve > budget/2 {
+		reserve = budget / 2
+	}
+	return context.WithTimeout(ctx, budget-reserve)
+}
+
 func (cr *ContentReader) addItemToMap(c Content, cm map[string]Content) {
 	id, ok := c[id].(string)
 	if !ok {
@@ -143,3 +282,13 @@ func (cr *ContentReader) addItemToMap(c Content, cm map[string]Content) {
 	}
 	cm[uuid] = c
 }
+
+func contentIDs(cb []Content) []string {
+	var ids []string
+	for _, c := range cb {
+		if cID, ok := c[id].(string); ok {
+			ids = append(ids, cID)
+		}
+	}
+	return ids
+}
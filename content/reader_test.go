@@ -1,6 +1,7 @@
 package content
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,7 +9,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -64,7 +68,7 @@ func TestGet(t *testing.T) {
 	err = json.Unmarshal(body, &expected)
 	assert.NoError(t, err, "Cannot read expected response for test case.")
 
-	actual, err := cr.Get(testData, "tid_1")
+	actual, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 	assert.Equal(t, expected, actual)
 }
@@ -74,7 +78,7 @@ func TestGet_ContentSourceReturns500(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest(ts.URL)
-	_, err := cr.Get(testData, "tid_1")
+	_, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
@@ -83,19 +87,19 @@ func TestGet_ContentSourceReturns404(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest(ts.URL)
-	_, err := cr.Get(testData, "tid_1")
+	_, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
 func TestGet_ContentSourceCannotBeResolved(t *testing.T) {
 	cr := readerForTest(unresolvedHostURL)
-	_, err := cr.Get(testData, "tid_1")
+	_, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
 func TestGet_ContentSourceHasInvalidURL(t *testing.T) {
 	cr := readerForTest(invalidHostURL)
-	_, err := cr.Get(testData, "tid_1")
+	_, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
@@ -111,7 +115,7 @@ func TestGetInternal(t *testing.T) {
 	err = json.Unmarshal(body, &expected)
 	assert.NoError(t, err, "Cannot read expected response for test case.")
 
-	actual, err := cr.GetInternal(testData, "tid_1")
+	actual, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 	assert.Equal(t, expected, actual)
 }
@@ -121,7 +125,7 @@ func TestGetInternal_ContentSourceReturns500(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest(ts.URL)
-	_, err := cr.GetInternal(testData, "tid_1")
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
@@ -130,18 +134,234 @@ func TestGetInternal_ContentSourceReturns404(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest(ts.URL)
-	_, err := cr.GetInternal(testData, "tid_1")
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
 func TestGetInternal_ContentSourceCannotBeResolved(t *testing.T) {
 	cr := readerForTest(unresolvedHostURL)
-	_, err := cr.GetInternal(testData, "tid_1")
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
 func TestGetInternal_ContentSourceHasInvalidURL(t *testing.T) {
 	cr := readerForTest(invalidHostURL)
-	_, err := cr.GetInternal(testData, "tid_1")
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
+
+func TestGetInternal_CoalescesConcurrentLookups(t *testing.T) {
+	var calls int32
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(requested)
+		}
+		<-release
+		file, err := os.Open("../test-resources/internalcontent-source-valid-response.json")
+		if err != nil {
+			assert.NoError(t, err, "File necessary for starting mock server not found.")
+			return
+		}
+		defer file.Close()
+		io.Copy(w, file)
+	}))
+	defer ts.Close()
+
+	cr := readerForTest(ts.URL)
+	uuids := []string{"d02886fc-58ff-11e8-9859-6668838a4c10"}
+
+	var wg sync.WaitGroup
+	results := make([]map[string]Content, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		res, err := cr.GetInternal(context.Background(), uuids, "tid_1")
+		assert.NoError(t, err, "Error while getting content data")
+		results[0] = res
+	}()
+	<-requested
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		res, err := cr.GetInternal(context.Background(), uuids, "tid_2")
+		assert.NoError(t, err, "Error while getting content data")
+		results[1] = res
+	}()
+	for cr.Metrics().CoalescedUUIDs == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Only one backend call should be made")
+	assert.Equal(t, results[0], results[1])
+	assert.Len(t, results[1], 1)
+
+	m := cr.Metrics()
+	assert.Equal(t, int64(1), m.BackendCalls)
+	assert.Equal(t, int64(2), m.RequestedUUIDs)
+	assert.Equal(t, int64(1), m.CoalescedUUIDs)
+	assert.Equal(t, int64(1), m.CoalescedCalls)
+}
+
+func TestGet_CancelledWhenContextIsDone(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	cr := readerForTest(ts.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := cr.Get(ctx, testData, "tid_1")
+	assert.Error(t, err, "There should an error thrown")
+	assert.True(t, time.Since(start) < time.Second, "Request should be cancelled with the context")
+}
+
+func TestWithBudgetReserve(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	reserved, cancelReserved := withBudgetReserve(ctx, 500*time.Millisecond)
+	defer cancelReserved()
+	deadline, ok := reserved.Deadline()
+	assert.True(t, ok, "Context should have a deadline")
+	assert.WithinDuration(t, time.Now().Add(1500*time.Millisecond), deadline, 50*time.Millisecond)
+
+	short, cancelShort := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancelShort()
+	halved, cancelHalved := withBudgetReserve(short, 500*time.Millisecond)
+	defer cancelHalved()
+	deadline, _ = halved.Deadline()
+	assert.WithinDuration(t, time.Now().Add(300*time.Millisecond), deadline, 50*time.Millisecond, "The reserve should be at most half the budget")
+
+	unbounded, cancelUnbounded := withBudgetReserve(context.Background(), 500*time.Millisecond)
+	defer cancelUnbounded()
+	_, ok = unbounded.Deadline()
+	assert.False(t, ok, "Context without deadline should stay without deadline")
+}
+
+func TestGetInternal_RetriesLookupWhenOwnerIsCancelled(t *testing.T) {
+	var calls int32
+	requested := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(requested)
+			<-r.Context().Done()
+			return
+		}
+		file, err := os.Open("../test-resources/internalcontent-source-valid-response.json")
+		if err != nil {
+			assert.NoError(t, err, "File necessary for starting mock server not found.")
+			return
+		}
+		defer file.Close()
+		io.Copy(w, file)
+	}))
+	defer ts.Close()
+
+	cr := readerForTest(ts.URL)
+	uuids := []string{"d02886fc-58ff-11e8-9859-6668838a4c10"}
+
+	ownerCtx, cancelOwner := context.WithCancel(context.Background())
+	ownerDone := make(chan struct{})
+	go func() {
+		defer close(ownerDone)
+		_, err := cr.GetInternal(ownerCtx, uuids, "tid_1")
+		assert.Error(t, err, "Cancelled lookup should fail")
+	}()
+	<-requested
+
+	var res map[string]Content
+	var err error
+	waiterDone := make(chan struct{})
+	go func() {
+		defer close(waiterDone)
+		res, err = cr.GetInternal(context.Background(), uuids, "tid_2")
+	}()
+	for cr.Metrics().CoalescedUUIDs == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancelOwner()
+	<-ownerDone
+	<-waiterDone
+
+	assert.NoError(t, err, "Waiting lookup should be retried")
+	assert.Len(t, res, 1)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestGetInternal_UsesInternalContentClient(t *testing.T) {
+	ts := successfulContentServerMock(t, "../test-resources/source-internalcontent-valid-lead-images-reasponse.json")
+	defer ts.Close()
+
+	var internalCalls int32
+	internalClient := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&internalCalls, 1)
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	cr := NewContentReader(ReaderConfig{
+		ContentStoreAppName:   "content-source-app-name",
+		ContentStoreHost:      ts.URL,
+		InternalContentClient: internalClient,
+	}, http.DefaultClient)
+
+	_, err := cr.Get(context.Background(), testData, "tid_1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&internalCalls))
+
+	_, err = cr.GetInternal(context.Background(), testData, "tid_1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&internalCalls))
+}
+
+func TestGet_ReleasesWaitersWhenOwnerPanics(t *testing.T) {
+	var cr *ContentReader
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		for cr.Metrics().CoalescedUUIDs == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		panic("broken transport")
+	})}
+	cr = NewContentReader(ReaderConfig{ContentStoreAppName: "content-source-app-name", ContentStoreHost: "http://content-store"}, client)
+	uuids := []string{"d02886fc-58ff-11e8-9859-6668838a4c10"}
+
+	ownerDone := make(chan interface{})
+	go func() {
+		defer func() { ownerDone <- recover() }()
+		cr.Get(context.Background(), uuids, "tid_1")
+	}()
+	for {
+		cr.flights.Lock()
+		n := len(cr.flights.calls)
+		cr.flights.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := cr.Get(ctx, uuids, "tid_2")
+	assert.Equal(t, "broken transport", <-ownerDone, "The panic should go on in the owner")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "panicked", "Waiters should get the panic as an error")
+	assert.Empty(t, cr.flights.calls)
+}
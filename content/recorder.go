@@ -0,0 +1,128 @@
+package content
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// replayHost is the content store host of the replay readers, never contacted.
+const replayHost = "http://replay"
+
+// recording is the response of the content store for a UUID, recorded by a ContentReader. The UUIDs are recorded
+// separately, so that the lookups can be replayed whatever the UUIDs they're coalesced with.
+type recording struct {
+	Endpoint string `json:"endpoint"`
+	UUID     string `json:"uuid"`
+	Status   int    `json:"status"`
+	// Body is the model returned, omitted when not found or on error
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// recordingFile returns the file of the recording for the endpoint and the UUID.
+func recordingFile(dir string, endpoint string, uuid string) string {
+	h := sha1.Sum([]byte(endpoint + "?" + uuid))
+	return filepath.Join(dir, hex.EncodeToString(h[:])+".json")
+}
+
+// record writes the response for every UUID requested to the record directory, when the reader has one.
+func (cr *ContentReader) record(tid string, reqURL string, uuids []string, status int, body []byte) {
+	dir := cr.config.RecordDir
+	if dir == "" {
+		return
+	}
+	endpoint := strings.TrimPrefix(reqURL, cr.config.ContentStoreHost)
+	var models []Content
+	if status == http.StatusOK {
+		if err := json.Unmarshal(body, &models); err != nil {
+			logger.Errorf(tid, "Cannot record response for %v: %v", uuids, err.Error())
+			return
+		}
+	}
+	byUUID := make(map[string]Content)
+	for _, m := range models {
+		mID, _ := m[id].(string)
+		if uuid, err := extractUUIDFromString(mID); err == nil {
+			byUUID[uuid] = m
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Errorf(tid, "Cannot record response for %v: %v", uuids, err.Error())
+		return
+	}
+	for _, uuid := range uuids {
+		rec := recording{Endpoint: endpoint, UUID: uuid, Status: status}
+		var err error
+		if m, found := byUUID[uuid]; found {
+			rec.Body, err = json.Marshal(m)
+		}
+		var b []byte
+		if err == nil {
+			b, err = json.MarshalIndent(rec, "", "  ")
+		}
+		if err == nil {
+			err = ioutil.WriteFile(recordingFile(dir, endpoint, uuid), b, 0644)
+		}
+		if err != nil {
+			logger.Errorf(tid, "Cannot record response for %v: %v", uuid, err.Error())
+		}
+	}
+}
+
+// replayTransport answers the requests of a ContentReader with the responses recorded in dir.
+type replayTransport struct {
+	dir string
+}
+
+func (rt replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	models := []json.RawMessage{}
+	for _, uuid := range req.URL.Query()["uuid"] {
+		b, err := ioutil.ReadFile(recordingFile(rt.dir, req.URL.Path, uuid))
+		if err != nil {
+			return nil, errors.Wrapf(err, "No recording for %v %v", req.URL.Path, uuid)
+		}
+		var rec recording
+		if err = json.Unmarshal(b, &rec); err != nil {
+			return nil, errors.Wrapf(err, "Invalid recording for %v %v", req.URL.Path, uuid)
+		}
+		if rec.Status != http.StatusOK {
+			return replayedResponse(req, rec.Status, nil), nil
+		}
+		if len(rec.Body) > 0 {
+			models = append(models, rec.Body)
+		}
+	}
+	b, err := json.Marshal(models)
+	if err != nil {
+		return nil, err
+	}
+	return replayedResponse(req, http.StatusOK, b), nil
+}
+
+func replayedResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// NewReplayReader returns a ContentReader serving the responses recorded in config.RecordDir instead of calling the
+// content store. Requests that weren't recorded fail, so the lookups of the recorded traffic are replayed exactly.
+func NewReplayReader(config ReaderConfig) *ContentReader {
+	client := &http.Client{Transport: replayTransport{dir: config.RecordDir}}
+	config.ContentStoreHost = replayHost
+	config.RecordDir = ""
+	config.InternalContentClient = nil
+	return NewContentReader(config, client)
+}
@@ -0,0 +1,104 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func recordingReaderForTest(t *testing.T, contentStoreHost string) (*ContentReader, string) {
+	dir, err := ioutil.TempDir("", "content-unroller")
+	assert.NoError(t, err)
+	return NewContentReader(ReaderConfig{
+		ContentStoreAppName:         "content-source-app-name",
+		ContentStoreHost:            contentStoreHost,
+		ContentPathEndpoint:         "/content",
+		InternalContentPathEndpoint: "/internalcontent",
+		RecordDir:                   dir,
+	}, http.DefaultClient), dir
+}
+
+func replayReaderForTest(dir string) *ContentReader {
+	return NewReplayReader(ReaderConfig{
+		ContentStoreAppName:         "content-source-app-name",
+		ContentPathEndpoint:         "/content",
+		InternalContentPathEndpoint: "/internalcontent",
+		RecordDir:                   dir,
+	})
+}
+
+// contentStoreMock answers with the models of the resource requested, like the content store.
+func contentStoreMock(t *testing.T, resource string) *httptest.Server {
+	b, err := ioutil.ReadFile(resource)
+	assert.NoError(t, err, "Cannot read file necessary for test case")
+	var models []Content
+	assert.NoError(t, json.Unmarshal(b, &models))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested := make(map[string]bool)
+		for _, uuid := range r.URL.Query()["uuid"] {
+			requested[uuid] = true
+		}
+		res := []Content{}
+		for _, m := range models {
+			if uuid, err := extractUUIDFromString(m[id].(string)); err == nil && requested[uuid] {
+				res = append(res, m)
+			}
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+}
+
+func TestReplayReader_ReplaysRecordedResponses(t *testing.T) {
+	ts := contentStoreMock(t, "../test-resources/source-content-valid-response.json")
+	cr, dir := recordingReaderForTest(t, ts.URL)
+	defer os.RemoveAll(dir)
+
+	recorded, err := cr.Get(context.Background(), testData, "tid_1")
+	assert.NoError(t, err)
+	ts.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	rr := replayReaderForTest(dir)
+	replayed, err := rr.Get(context.Background(), []string{testData[3], testData[2], testData[0]}, "tid_1")
+	assert.NoError(t, err, "The order of the UUIDs should not matter")
+	assert.Equal(t, recorded, replayed)
+
+	for _, uuid := range []string{testData[0], testData[2]} {
+		single, err := rr.Get(context.Background(), []string{uuid}, "tid_1")
+		assert.NoError(t, err, "Every UUID should be replayed whatever the UUIDs it was coalesced with")
+		assert.Contains(t, single, uuid)
+		assert.Equal(t, recorded[uuid], single[uuid])
+	}
+
+	_, err = rr.GetInternal(context.Background(), testData, "tid_1")
+	assert.Error(t, err, "Requests that weren't recorded should fail")
+}
+
+func TestReplayReader_ReplaysRecordedErrors(t *testing.T) {
+	ts := errorContentServerMock(t, http.StatusServiceUnavailable)
+	cr, dir := recordingReaderForTest(t, ts.URL)
+	defer os.RemoveAll(dir)
+
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
+	assert.Error(t, err)
+	ts.Close()
+
+	_, replayErr := replayReaderForTest(dir).GetInternal(context.Background(), testData, "tid_1")
+	assert.Equal(t, err.Error(), replayErr.Error())
+}
+
+func TestRecordingFile_IsKeyedByEndpointAndUUID(t *testing.T) {
+	assert.Equal(t, recordingFile("rec", "/content", otherUUID), recordingFile("rec", "/content", otherUUID))
+	assert.NotEqual(t, recordingFile("rec", "/content", otherUUID), recordingFile("rec", "/content", imageSetUUID))
+	assert.NotEqual(t, recordingFile("rec", "/content", otherUUID), recordingFile("rec", "/internalcontent", otherUUID))
+}
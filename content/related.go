@@ -0,0 +1,212 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	storyPackage = "storyPackage"
+	containedIn  = "containedIn"
+	contains     = "contains"
+)
+
+// ExpandRelated expands the storyPackage, containedIn and contains references into summaries of the content.
+const ExpandRelated = "related"
+
+// expandParam is the query parameter listing the optional expansions, e.g. "?expand=related".
+const expandParam = "expand"
+
+var knownExpansions = map[string]bool{ExpandRelated: true, ExpandBrands: true, ExpandAnnotations: true}
+
+// summaryFields are the fields of related content kept in its summary, together with the resolved main image.
+var summaryFields = []string{id, apiURL, "type", "title", "alternativeTitles", "standfirst", "alternativeStandfirsts", "publishedDate", lastModified, "webUrl"}
+
+// expansions are the optional expansions requested for an unroll.
+type expansions map[string]bool
+
+func parseExpansions(r *http.Request) (expansions, error) {
+	return parseExpansionList(r.URL.Query().Get(expandParam))
+}
+
+// parseExpansionList parses a comma separated list of expansions, e.g. "related,brands".
+func parseExpansionList(raw string) (expansions, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	exp := make(expansions)
+	for _, e := range strings.Split(raw, ",") {
+		e = strings.TrimSpace(e)
+		if !knownExpansions[e] {
+			return nil, requestError{http.StatusBadRequest, "Unknown expansion " + e}
+		}
+		exp[e] = true
+	}
+	return exp, nil
+}
+
+// String returns the expansions sorted, so they can be part of a cache key.
+func (e expansions) String() string {
+	var names []string
+	for k := range e {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+type relatedRef struct {
+	path string
+	uuid string
+}
+
+// unrollRelated replaces the related content references of the article with summaries of the content.
+// References that can't be resolved are left as they are.
+func (u *ContentUnroller) unrollRelated(ctx context.Context, cc Content, tid string, uuid string, report *unrollReport) {
+	var refs []relatedRef
+	addRef := func(path string, ref interface{}) {
+		m, ok := ref.(map[string]interface{})
+		if !ok {
+			return
+		}
+		rID, _ := m[id].(string)
+		rUUID, err := extractUUIDFromString(rID)
+		if err != nil {
+			logger.Infof(tid, uuid, "Cannot find related content %s: %v. Skipping expanding it", path, err.Error())
+			report.add(rID, path, reasonInvalidUUID)
+			return
+		}
+		refs = append(refs, relatedRef{path: path, uuid: rUUID})
+	}
+
+	addRef(storyPackage, cc[storyPackage])
+	for _, f := range []string{containedIn, contains} {
+		list, _ := cc[f].([]interface{})
+		for i, item := range list {
+			addRef(indexedPath(f, i), item)
+		}
+	}
+	if len(refs) == 0 {
+		logger.Info(tid, uuid, "No related content to expand for supplied content")
+		return
+	}
+
+	var uuids []string
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if !seen[ref.uuid] {
+			uuids = append(uuids, ref.uuid)
+			seen[ref.uuid] = true
+		}
+	}
+	related, err := u.reader.Get(ctx, uuids, tid)
+	if err != nil {
+		logger.Errorf(tid, "Error while getting related content %s", err.Error())
+		for _, ref := range refs {
+			report.add(ref.uuid, ref.path, reasonBackendError)
+		}
+		return
+	}
+
+	summaries := u.summarise(ctx, related, tid, uuid)
+	for _, ref := range refs {
+		s, found := summaries[ref.uuid]
+		if !found {
+			report.add(ref.uuid, ref.path, reasonNotFound)
+			continue
+		}
+		report.addNested(ref.path, s.unresolved)
+	}
+
+	if s, found := summaries[firstUUID(refs, storyPackage)]; found {
+		cc[storyPackage] = s.c
+	}
+	for _, f := range []string{containedIn, contains} {
+		list, ok := cc[f].([]interface{})
+		if !ok {
+			continue
+		}
+		// the article is only shallow copied, so the lists are copied before being expanded
+		expList := make([]interface{}, len(list))
+		for i, item := range list {
+			expList[i] = item
+			if s, found := summaries[firstUUID(refs, indexedPath(f, i))]; found {
+				expList[i] = s.c
+			}
+		}
+		cc[f] = expList
+	}
+}
+
+type summary struct {
+	c          Content
+	unresolved []UnresolvedReference
+}
+
+// summarise projects the related content to its summary fields and resolves its main image.
+func (u *ContentUnroller) summarise(ctx context.Context, related map[string]Content, tid string, uuid string) map[string]summary {
+	summaries := make(map[string]summary)
+	mainImageUUIDs := make(map[string]string)
+	var imgUUIDs []string
+	for rUUID, c := range related {
+		s := Content{}
+		for _, f := range summaryFields {
+			if v, found := c[f]; found {
+				s[f] = v
+			}
+		}
+		if mi, ok := c[mainImage].(map[string]interface{}); ok {
+			s[mainImage] = mi
+			miID, _ := mi[id].(string)
+			if miUUID, err := extractUUIDFromString(miID); err == nil {
+				mainImageUUIDs[rUUID] = miUUID
+				imgUUIDs = append(imgUUIDs, miUUID)
+			}
+		}
+		summaries[rUUID] = summary{c: s}
+	}
+	if len(imgUUIDs) == 0 {
+		return summaries
+	}
+	sort.Strings(imgUUIDs)
+
+	images, err := u.reader.Get(ctx, imgUUIDs, tid)
+	if err != nil {
+		logger.Errorf(tid, "Error while getting main images of related content %s", err.Error())
+	}
+
+	resolvedMembers := make(map[string][]UnresolvedReference)
+	for rUUID, miUUID := range mainImageUUIDs {
+		s := summaries[rUUID]
+		img, found := images[miUUID]
+		switch {
+		case err != nil:
+			s.unresolved = append(s.unresolved, UnresolvedReference{UUID: miUUID, Path: mainImage, Reason: reasonBackendError})
+		case !found:
+			s.unresolved = append(s.unresolved, UnresolvedReference{UUID: miUUID, Path: mainImage, Reason: reasonNotFound})
+		default:
+			refs, resolved := resolvedMembers[miUUID]
+			if !resolved {
+				refs = u.resolveMembers(img, images, tid, uuid)
+				resolvedMembers[miUUID] = refs
+			}
+			var nested unrollReport
+			nested.addNested(mainImage, refs)
+			s.unresolved = append(s.unresolved, nested...)
+			s.c[mainImage] = img
+		}
+		summaries[rUUID] = s
+	}
+	return summaries
+}
+
+func firstUUID(refs []relatedRef, path string) string {
+	for _, ref := range refs {
+		if ref.path == path {
+			return ref.uuid
+		}
+	}
+	return ""
+}
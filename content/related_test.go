@@ -0,0 +1,137 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const packageUUID = "4855afce-10a4-11e7-b030-768954394623"
+
+func relatedModels() map[string]Content {
+	models := imageSetModels()
+	models[packageUUID] = Content{
+		id:         "http://www.ft.com/thing/" + packageUUID,
+		"type":     "http://www.ft.com/ontology/content/ContentPackage",
+		"title":    "Brexit explained",
+		bodyXML:    "<body></body>",
+		mainImage:  map[string]interface{}{id: "http://api.ft.com/content/" + imageSetUUID},
+		"contains": []interface{}{},
+	}
+	models[parentUUID] = Content{
+		id:           "http://www.ft.com/thing/" + parentUUID,
+		"title":      "Article 50",
+		"standfirst": "Annotated transcript",
+	}
+	return models
+}
+
+func relatedArticle() Content {
+	return Content{
+		id:           "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		storyPackage: map[string]interface{}{id: "http://api.ft.com/content/" + packageUUID},
+		containedIn:  []interface{}{map[string]interface{}{id: "http://api.ft.com/content/" + parentUUID}},
+		contains: []interface{}{
+			map[string]interface{}{id: "http://api.ft.com/content/" + missingUUID},
+			map[string]interface{}{id: "http://api.ft.com/content/not-uuid"},
+		},
+	}
+}
+
+func TestUnrollContent_ExpandsRelatedContent(t *testing.T) {
+	var calls [][]string
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+				calls = append(calls, uuids)
+				models := relatedModels()
+				res := make(map[string]Content)
+				for _, u := range uuids {
+					if c, found := models[u]; found {
+						res[u] = c
+					}
+				}
+				if _, found := res[imageSetUUID]; found {
+					res[memberUUID] = models[memberUUID]
+				}
+				return res, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := relatedArticle()
+	res := cu.UnrollContent(context.Background(), UnrollEvent{article, "tid_sample", "22c0d426-1466-11e7-b0c1-37e417ee6c76", expansions{ExpandRelated: true}})
+	assert.NoError(t, res.err)
+	assert.Equal(t, [][]string{{packageUUID, parentUUID, missingUUID}, {imageSetUUID}}, calls)
+
+	sp := res.uc[storyPackage].(Content)
+	assert.Equal(t, "Brexit explained", sp["title"])
+	assert.NotContains(t, sp, bodyXML, "Only the summary fields should be returned")
+	assert.NotContains(t, sp, "contains")
+	assert.Equal(t, []Content{{id: "http://www.ft.com/thing/" + memberUUID}}, sp[mainImage].(Content)[members], "Main image of related content should be resolved")
+
+	assert.Equal(t, Content{id: "http://www.ft.com/thing/" + parentUUID, "title": "Article 50", "standfirst": "Annotated transcript"},
+		res.uc[containedIn].([]interface{})[0])
+	assert.Equal(t, article[contains], res.uc[contains], "Unresolved references should be left as they are")
+	assert.Equal(t, []UnresolvedReference{
+		{UUID: "http://api.ft.com/content/not-uuid", Path: "contains[1]", Reason: reasonInvalidUUID},
+		{UUID: missingUUID, Path: "contains[0]", Reason: reasonNotFound},
+	}, res.unresolved)
+
+	assert.IsType(t, map[string]interface{}{}, article[containedIn].([]interface{})[0], "The supplied article should not be modified")
+}
+
+func TestUnrollContent_DoesNotExpandRelatedContentByDefault(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+				assert.Fail(t, "Related content should not be read")
+				return nil, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := relatedArticle()
+	res := cu.UnrollContent(context.Background(), UnrollEvent{article, "tid_sample", "22c0d426-1466-11e7-b0c1-37e417ee6c76", nil})
+	assert.NoError(t, res.err)
+	assert.Equal(t, article[storyPackage], res.uc[storyPackage])
+}
+
+func TestGetContent_RejectsUnknownExpansions(t *testing.T) {
+	h := Handler{Service: &ContentUnrollerMock{}}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	req := httptest.NewRequest(http.MethodPost, "/content?expand=related,everything", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Unknown expansion everything", rr.Body.String())
+}
+
+func TestGetContent_PassesExpansionsToTheUnroller(t *testing.T) {
+	var expand expansions
+	h := Handler{Service: &ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			expand = req.expand
+			return UnrollResult{req.c, nil, nil}
+		},
+	}}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	req := httptest.NewRequest(http.MethodPost, "/content?expand=related", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, expansions{ExpandRelated: true}, expand)
+}
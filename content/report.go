@@ -0,0 +1,78 @@
+package content
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnrollMissingHeader lists the references that couldn't be resolved while unrolling the content.
+const UnrollMissingHeader = "X-Unroll-Missing"
+
+// unrollReportParam is the query parameter adding the _unroll block to the unrolled content when set to true.
+const unrollReportParam = "unrollReport"
+
+const unrollReportField = "_unroll"
+
+// Reasons for a reference not being resolved.
+const (
+	reasonNotFound     = "not_found"
+	reasonInvalidUUID  = "invalid_uuid"
+	reasonBackendError = "backend_error"
+)
+
+// UnresolvedReference is a reference to a model that was left as a placeholder in the unrolled content.
+// Path is where the reference is found in the content, e.g. "embeds[2].members[0]".
+type UnresolvedReference struct {
+	UUID   string `json:"uuid"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type unrollReport []UnresolvedReference
+
+func (r *unrollReport) add(uuid string, path string, reason string) {
+	*r = append(*r, UnresolvedReference{UUID: uuid, Path: path, Reason: reason})
+}
+
+// addNested records the references unresolved within the model found at path.
+func (r *unrollReport) addNested(path string, refs []UnresolvedReference) {
+	for _, ref := range refs {
+		p := path
+		if ref.Path != "" {
+			p = path + "." + ref.Path
+		}
+		r.add(ref.UUID, p, ref.Reason)
+	}
+}
+
+// hasBackendError tells whether any of the references is unresolved because a backend failed.
+func hasBackendError(refs []UnresolvedReference) bool {
+	for _, ref := range refs {
+		if ref.Reason == reasonBackendError {
+			return true
+		}
+	}
+	return false
+}
+
+func indexedPath(field string, i int) string {
+	return fmt.Sprintf("%s[%d]", field, i)
+}
+
+func formatUnresolvedHeader(refs []UnresolvedReference) string {
+	var entries []string
+	for _, ref := range refs {
+		entries = append(entries, fmt.Sprintf("%s;uuid=%s;reason=%s", ref.Path, ref.UUID, ref.Reason))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// withUnrollReport returns a copy of the unrolled content including the _unroll block.
+func withUnrollReport(uc Content, refs []UnresolvedReference) Content {
+	if refs == nil {
+		refs = []UnresolvedReference{}
+	}
+	c := uc.clone()
+	c[unrollReportField] = map[string]interface{}{"unresolved": refs}
+	return c
+}
@@ -0,0 +1,244 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	contentEndpoint         = "content"
+	internalContentEndpoint = "internalcontent"
+)
+
+// SourceConfig describes a content store the models can be read from.
+type SourceConfig struct {
+	Name                        string `json:"name"`
+	AppName                     string `json:"appName"`
+	Host                        string `json:"host"`
+	ContentPathEndpoint         string `json:"contentPathEndpoint"`
+	InternalContentPathEndpoint string `json:"internalContentPathEndpoint"`
+	// Types restricts the models accepted from the source to the given content types. All types are accepted when empty.
+	Types []string `json:"types,omitempty"`
+}
+
+func (sc SourceConfig) ReaderConfig() ReaderConfig {
+	return ReaderConfig{
+		ContentStoreAppName:         sc.AppName,
+		ContentStoreHost:            sc.Host,
+		ContentPathEndpoint:         sc.ContentPathEndpoint,
+		InternalContentPathEndpoint: sc.InternalContentPathEndpoint,
+	}
+}
+
+// RouteConfig lists the sources, in fallback order, used for the UUIDs matching the route.
+// Empty Endpoint ("content" or "internalcontent") and UUIDPrefix match everything.
+type RouteConfig struct {
+	Endpoint   string   `json:"endpoint,omitempty"`
+	UUIDPrefix string   `json:"uuidPrefix,omitempty"`
+	Sources    []string `json:"sources"`
+}
+
+type SourcesConfig struct {
+	Sources []SourceConfig `json:"sources"`
+	Routes  []RouteConfig  `json:"routes"`
+}
+
+// ReadSourcesConfig loads and validates the sources configuration from a JSON file.
+func ReadSourcesConfig(path string) (SourcesConfig, error) {
+	var sc SourcesConfig
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return sc, errors.Wrapf(err, "Cannot read sources config %v", path)
+	}
+	if err = json.Unmarshal(b, &sc); err != nil {
+		return sc, errors.Wrapf(err, "Cannot parse sources config %v", path)
+	}
+	return sc, sc.validate()
+}
+
+func (sc SourcesConfig) validate() error {
+	if len(sc.Sources) == 0 {
+		return errors.New("No content sources configured")
+	}
+	names := make(map[string]bool)
+	for _, s := range sc.Sources {
+		if s.Name == "" || s.Host == "" {
+			return errors.New("Content sources need a name and a host")
+		}
+		if names[s.Name] {
+			return errors.Errorf("Duplicate content source %v", s.Name)
+		}
+		names[s.Name] = true
+	}
+	for _, r := range sc.Routes {
+		if r.Endpoint != "" && r.Endpoint != contentEndpoint && r.Endpoint != internalContentEndpoint {
+			return errors.Errorf("Unknown endpoint %v in route", r.Endpoint)
+		}
+		if len(r.Sources) == 0 {
+			return errors.Errorf("Route for %v%v has no sources", r.Endpoint, r.UUIDPrefix)
+		}
+		for _, s := range r.Sources {
+			if !names[s] {
+				return errors.Errorf("Route references unknown content source %v", s)
+			}
+		}
+	}
+	return nil
+}
+
+// Source is a named Reader used by the RoutingReader.
+type Source struct {
+	Name   string
+	Reader Reader
+	Types  []string
+}
+
+func (s Source) accepts(c Content) bool {
+	if len(s.Types) == 0 {
+		return true
+	}
+	t, _ := c["type"].(string)
+	for _, accepted := range s.Types {
+		if t == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// RoutingReader reads every UUID from the sources of the first matching route, falling back to the next source
+// of the route for the UUIDs not found or when the source fails. UUIDs not matching any route are looked up in all
+// sources, in order. Reading fails when a UUID isn't found in any of its sources and one of them failed.
+type RoutingReader struct {
+	sources map[string]Source
+	order   []string
+	routes  []RouteConfig
+}
+
+func NewRoutingReader(sources []Source, routes []RouteConfig) *RoutingReader {
+	rr := &RoutingReader{
+		sources: make(map[string]Source),
+		routes:  routes,
+	}
+	for _, s := range sources {
+		rr.sources[s.Name] = s
+		rr.order = append(rr.order, s.Name)
+	}
+	return rr
+}
+
+func (rr *RoutingReader) Get(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	cm := make(map[string]Content)
+	if err := rr.route(ctx, uuids, tid, contentEndpoint, cm); err != nil {
+		return cm, err
+	}
+
+	// image sets and their members are not necessarily stored in the same source
+	var missingMembers []string
+	seen := make(map[string]bool)
+	for _, c := range cm {
+		for _, mUUID := range c.getMembersUUID() {
+			if _, found := cm[mUUID]; !found && !seen[mUUID] {
+				missingMembers = append(missingMembers, mUUID)
+				seen[mUUID] = true
+			}
+		}
+	}
+	sort.Strings(missingMembers)
+	if len(missingMembers) == 0 {
+		return cm, nil
+	}
+	return cm, rr.route(ctx, missingMembers, tid, contentEndpoint, cm)
+}
+
+func (rr *RoutingReader) GetInternal(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	cm := make(map[string]Content)
+	return cm, rr.route(ctx, uuids, tid, internalContentEndpoint, cm)
+}
+
+func (rr *RoutingReader) route(ctx context.Context, uuids []string, tid string, endpoint string, cm map[string]Content) error {
+	pending := make(map[string][]string)
+	var ordered []string
+	for _, uuid := range uuids {
+		if _, found := cm[uuid]; found {
+			continue
+		}
+		if _, found := pending[uuid]; !found {
+			pending[uuid] = rr.sourcesFor(endpoint, uuid)
+			ordered = append(ordered, uuid)
+		}
+	}
+
+	// the last error of the sources of every UUID, reported when no other source has it
+	failed := make(map[string]error)
+	for len(pending) > 0 {
+		// every round asks each source for the UUIDs having it next in their fallback chain
+		batches := make(map[string][]string)
+		for _, uuid := range ordered {
+			chain, found := pending[uuid]
+			if !found {
+				continue
+			}
+			if len(chain) == 0 {
+				delete(pending, uuid)
+				continue
+			}
+			batches[chain[0]] = append(batches[chain[0]], uuid)
+			pending[uuid] = chain[1:]
+		}
+
+		for _, name := range rr.order {
+			batch, found := batches[name]
+			if !found {
+				continue
+			}
+			src := rr.sources[name]
+			var res map[string]Content
+			var err error
+			if endpoint == internalContentEndpoint {
+				res, err = src.Reader.GetInternal(ctx, batch, tid)
+			} else {
+				res, err = src.Reader.Get(ctx, batch, tid)
+			}
+			if err != nil {
+				err = errors.Wrapf(err, "Error reading from content source %v", name)
+				logger.Warnf(tid, "", "%v, falling back to the next source for %v", err, batch)
+				for _, uuid := range batch {
+					failed[uuid] = err
+				}
+				continue
+			}
+			for uuid, c := range res {
+				if _, found := cm[uuid]; found || !src.accepts(c) {
+					continue
+				}
+				cm[uuid] = c
+				delete(pending, uuid)
+			}
+		}
+	}
+
+	for _, uuid := range ordered {
+		if _, found := cm[uuid]; !found && failed[uuid] != nil {
+			return failed[uuid]
+		}
+	}
+	return nil
+}
+
+func (rr *RoutingReader) sourcesFor(endpoint string, uuid string) []string {
+	for _, r := range rr.routes {
+		if r.Endpoint != "" && r.Endpoint != endpoint {
+			continue
+		}
+		if strings.HasPrefix(uuid, r.UUIDPrefix) {
+			return r.Sources
+		}
+	}
+	return rr.order
+}
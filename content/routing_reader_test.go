@@ -0,0 +1,134 @@
+package content
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func sourceReader(name string, models map[string]Content, calls *[]string) *ReaderMock {
+	get := func(uuids []string, tid string) (map[string]Content, error) {
+		res := make(map[string]Content)
+		for _, u := range uuids {
+			*calls = append(*calls, name+":"+u)
+			if c, found := models[u]; found {
+				res[u] = c
+			}
+		}
+		return res, nil
+	}
+	return &ReaderMock{mockGet: get, mockGetInternal: get}
+}
+
+func TestRoutingReader_FallsBackToNextSource(t *testing.T) {
+	var calls []string
+	models := imageSetModels()
+	rr := NewRoutingReader([]Source{
+		{Name: "published", Reader: sourceReader("published", map[string]Content{otherUUID: models[otherUUID]}, &calls)},
+		{Name: "draft", Reader: sourceReader("draft", models, &calls)},
+	}, nil)
+
+	res, err := rr.Get(context.Background(), []string{otherUUID, imageSetUUID}, "tid_1")
+	assert.NoError(t, err)
+	assert.Len(t, res, 3, "Image set members should be resolved from the fallback source as well")
+	assert.Equal(t, []string{"published:" + otherUUID, "published:" + imageSetUUID, "draft:" + imageSetUUID,
+		"published:" + memberUUID, "draft:" + memberUUID}, calls)
+}
+
+func TestRoutingReader_RoutesByEndpointAndUUIDPrefix(t *testing.T) {
+	var calls []string
+	models := imageSetModels()
+	rr := NewRoutingReader([]Source{
+		{Name: "published", Reader: sourceReader("published", models, &calls)},
+		{Name: "archive", Reader: sourceReader("archive", models, &calls)},
+	}, []RouteConfig{
+		{Endpoint: internalContentEndpoint, UUIDPrefix: "7123", Sources: []string{"archive"}},
+	})
+
+	res, err := rr.GetInternal(context.Background(), []string{otherUUID, memberUUID}, "tid_1")
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, []string{"published:" + memberUUID, "archive:" + otherUUID}, calls)
+
+	calls = nil
+	_, err = rr.Get(context.Background(), []string{otherUUID}, "tid_2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"published:" + otherUUID}, calls, "Route should only apply to its endpoint")
+}
+
+func TestRoutingReader_SkipsModelsOfOtherTypes(t *testing.T) {
+	var calls []string
+	models := imageSetModels()
+	rr := NewRoutingReader([]Source{
+		{Name: "images", Reader: sourceReader("images", models, &calls), Types: []string{ImageSetType}},
+		{Name: "published", Reader: sourceReader("published", models, &calls)},
+	}, nil)
+
+	res, err := rr.GetInternal(context.Background(), []string{imageSetUUID, otherUUID}, "tid_1")
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, []string{"images:" + imageSetUUID, "images:" + otherUUID, "published:" + otherUUID}, calls)
+}
+
+func TestRoutingReader_ReturnsSourceErrors(t *testing.T) {
+	rr := NewRoutingReader([]Source{
+		{Name: "published", Reader: &ReaderMock{mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			return nil, errors.New("Service unavailable")
+		}}},
+	}, nil)
+
+	_, err := rr.Get(context.Background(), []string{otherUUID}, "tid_1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "published")
+}
+
+func TestRoutingReader_FallsBackWhenSourceFails(t *testing.T) {
+	rr := NewRoutingReader([]Source{
+		{Name: "published", Reader: &ReaderMock{mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			return nil, errors.New("Service unavailable")
+		}}},
+		{Name: "archive", Reader: &ReaderMock{mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			return map[string]Content{otherUUID: {id: otherUUID}}, nil
+		}}},
+	}, nil)
+
+	cm, err := rr.Get(context.Background(), []string{otherUUID}, "tid_1")
+	assert.NoError(t, err)
+	assert.Contains(t, cm, otherUUID)
+
+	_, err = rr.Get(context.Background(), []string{otherUUID, missingUUID}, "tid_1")
+	assert.Error(t, err, "A UUID not found anywhere should fail when one of its sources failed")
+	assert.Contains(t, err.Error(), "published")
+}
+
+func TestReadSourcesConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sources")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.json")
+	assert.NoError(t, ioutil.WriteFile(valid, []byte(`{
+		"sources": [
+			{"name": "published", "appName": "content-public-read", "host": "http://localhost:8080", "contentPathEndpoint": "/content"},
+			{"name": "draft", "appName": "draft-content-public-read", "host": "http://localhost:8081", "contentPathEndpoint": "/content"}
+		],
+		"routes": [{"endpoint": "content", "sources": ["draft", "published"]}]
+	}`), 0644))
+	sc, err := ReadSourcesConfig(valid)
+	assert.NoError(t, err)
+	assert.Len(t, sc.Sources, 2)
+	assert.Equal(t, "http://localhost:8081", sc.Sources[1].ReaderConfig().ContentStoreHost)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	assert.NoError(t, ioutil.WriteFile(invalid, []byte(`{
+		"sources": [{"name": "published", "host": "http://localhost:8080"}],
+		"routes": [{"sources": ["archive"]}]
+	}`), 0644))
+	_, err = ReadSourcesConfig(invalid)
+	assert.Error(t, err, "Routes to unknown sources should be rejected")
+}
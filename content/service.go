@@ -1,6 +1,10 @@
 package content
 
 import (
+	"context"
+	"sort"
+	"strings"
+
 	"github.com/pkg/errors"
 )
 
@@ -16,90 +20,153 @@ const (
 	bodyXML            = "bodyXML"
 	promotionalImage   = "promotionalImage"
 	image              = "image"
+	lastModified       = "lastModified"
+	apiURL             = "apiUrl"
+	defaultAPIScheme   = "http"
 )
 
 type Unroller interface {
-	UnrollContent(UnrollEvent) UnrollResult
-	UnrollInternalContent(UnrollEvent) UnrollResult
+	UnrollContent(context.Context, UnrollEvent) UnrollResult
+	UnrollInternalContent(context.Context, UnrollEvent) UnrollResult
 }
 
 type ContentUnroller struct {
-	reader  Reader
-	apiHost string
+	reader       Reader
+	apiHost      string
+	apiScheme    string
+	canonicalIDs bool
+	concepts     ConceptReader
+}
+
+type UnrollerConfig struct {
+	APIHost string
+	// APIScheme is the scheme of the generated ids, "http" when empty.
+	APIScheme string
+	// CanonicalIDs rewrites the ids of all the expanded models to the apiHost based form.
+	CanonicalIDs bool
+	// ConceptReader reads the brands and annotations to expand. Concepts are not expanded when nil.
+	ConceptReader ConceptReader
 }
 
 type Content map[string]interface{}
 
 type ContentSchema map[string][]string
 
-func NewContentUnroller(r Reader, apiHost string) *ContentUnroller {
+func NewContentUnroller(r Reader, config UnrollerConfig) *ContentUnroller {
 	return &ContentUnroller{
-		reader:  r,
-		apiHost: apiHost,
+		reader:       r,
+		apiHost:      config.APIHost,
+		apiScheme:    config.APIScheme,
+		canonicalIDs: config.CanonicalIDs,
+		concepts:     config.ConceptReader,
 	}
 }
 
-func (u *ContentUnroller) UnrollContent(req UnrollEvent) UnrollResult {
+func (u *ContentUnroller) UnrollContent(ctx context.Context, req UnrollEvent) UnrollResult {
 	//make a copy of the content
 	cc := req.c.clone()
+	report := unrollReport{}
 
-	schema := u.createContentSchema(cc, []string{ImageSetType, DynamicContentType}, req.tid, req.uuid)
+	schema := u.createContentSchema(cc, []string{ImageSetType, DynamicContentType}, req.tid, req.uuid, &report)
+	logger.Debugf(req.tid, req.uuid, "Content schema: %v", schema)
 	if schema != nil {
-		contentMap, err := u.reader.Get(schema.toArray(), req.tid)
+		contentMap, err := u.reader.Get(ctx, schema.toArray(), req.tid)
 		if err != nil {
-			return UnrollResult{req.c, errors.Wrapf(err, "Error while getting expanded content for uuid: %v", req.uuid)}
+			return UnrollResult{req.c, errors.Wrapf(err, "Error while getting expanded content for uuid: %v", req.uuid), nil}
 		}
-		u.resolveModelsForSetsMembers(schema, contentMap, req.tid, req.tid)
+
+		// image sets not found get a placeholder when resolving the members, so the missing alternative images
+		// have to be known beforehand to leave them untouched
+		altImageRefs := schema.getAltImages()
+		missingAltImages := make(map[string]bool)
+		for _, ai := range altImageRefs {
+			if _, found := contentMap[ai.uuid]; !found {
+				missingAltImages[ai.name] = true
+			}
+		}
+
+		unresolved := u.resolveModelsForSetsMembers(schema, contentMap, req.tid, req.uuid)
 
 		mainImageUUID := schema.get(mainImage)
 		if mainImageUUID != "" {
 			cc[mainImage] = contentMap[mainImageUUID]
+			report.addNested(mainImage, unresolved[mainImageUUID])
 		}
 
 		embeddedContentUUIDs := schema.getAll(embeds)
 		if len(embeddedContentUUIDs) > 0 {
 			embedded := []Content{}
-			for _, emb := range embeddedContentUUIDs {
+			for i, emb := range embeddedContentUUIDs {
 				embedded = append(embedded, contentMap[emb])
+				report.addNested(indexedPath(embeds, i), unresolved[emb])
 			}
 			cc[embeds] = embedded
 		}
 
-		promImgUUID := schema.get(promotionalImage)
-		if promImgUUID != "" {
-			pi, found := contentMap[promImgUUID]
-			if found {
-				cc[altImages].(map[string]interface{})[promotionalImage] = pi
+		if len(altImageRefs) > 0 {
+			// the article is only shallow copied, so its alternative images are copied before being expanded
+			expAltImages := fromMap(cc[altImages].(map[string]interface{}))
+			for _, ai := range altImageRefs {
+				if missingAltImages[ai.name] {
+					report.add(ai.uuid, altImages+"."+ai.name, reasonNotFound)
+					continue
+				}
+				expAltImages[ai.name] = contentMap[ai.uuid]
+				report.addNested(altImages+"."+ai.name, unresolved[ai.uuid])
 			}
+			cc[altImages] = map[string]interface{}(expAltImages)
 		}
 	}
 
-	return UnrollResult{cc, nil}
+	if req.expand[ExpandRelated] {
+		u.unrollRelated(ctx, cc, req.tid, req.uuid, &report)
+	}
+	u.unrollConcepts(ctx, cc, req, &report)
+
+	if u.canonicalIDs {
+		u.canonicaliseExpanded(cc)
+	}
+	return UnrollResult{cc, nil, report}
 }
 
-func (u *ContentUnroller) UnrollInternalContent(req UnrollEvent) UnrollResult {
+func (u *ContentUnroller) UnrollInternalContent(ctx context.Context, req UnrollEvent) UnrollResult {
 	cc := req.c.clone()
-	expLeadImages, foundImages := u.unrollLeadImages(cc, req.tid, req.uuid)
+	report := unrollReport{}
+	expLeadImages, foundImages := u.unrollLeadImages(ctx, cc, req.tid, req.uuid, &report)
 	if foundImages {
 		cc[leadImages] = expLeadImages
 	}
 
-	dynContents, foundDyn := u.unrollDynamicContent(cc, req.tid, req.uuid, u.reader.GetInternal)
+	dynContents, foundDyn := u.unrollDynamicContent(ctx, cc, req.tid, req.uuid, u.reader.GetInternal, &report)
 	if foundDyn {
 		cc[embeds] = dynContents
 	}
 
-	return UnrollResult{cc, nil}
+	if req.expand[ExpandRelated] {
+		u.unrollRelated(ctx, cc, req.tid, req.uuid, &report)
+	}
+	u.unrollConcepts(ctx, cc, req, &report)
+
+	if err := ctx.Err(); err != nil {
+		return UnrollResult{req.c, errors.Wrapf(err, "Error while getting expanded internal content for uuid: %v", req.uuid), nil}
+	}
+
+	if u.canonicalIDs {
+		u.canonicaliseExpanded(cc)
+	}
+	return UnrollResult{cc, nil, report}
 }
 
-func (u *ContentUnroller) createContentSchema(cc Content, acceptedTypes []string, tid string, uuid string) ContentSchema {
+func (u *ContentUnroller) createContentSchema(cc Content, acceptedTypes []string, tid string, uuid string, report *unrollReport) ContentSchema {
 	//mainImage
 	schema := make(ContentSchema)
 	mi, foundMainImg := cc[mainImage].(map[string]interface{})
 	if foundMainImg {
-		u, err := extractUUIDFromString(mi[id].(string))
+		miID, _ := mi[id].(string)
+		u, err := extractUUIDFromString(miID)
 		if err != nil {
 			logger.Infof(tid, uuid, "Cannot find main image: %v. Skipping expanding main image", err.Error())
+			report.add(miID, mainImage, reasonInvalidUUID)
 			foundMainImg = false
 		} else {
 			schema.put(mainImage, u)
@@ -114,39 +181,38 @@ func (u *ContentUnroller) createContentSchema(cc Content, acceptedTypes []string
 		schema.putAll(embeds, emContentUUIDs)
 	}
 
-	//promotional image
-	var foundPromImg bool
+	//alternative images - promotional, square, wide, social...
+	var foundAltImg bool
 	altImg, found := cc[altImages].(map[string]interface{})
 	if found {
-		var promImg map[string]interface{}
-		promImg, foundPromImg = altImg[promotionalImage].(map[string]interface{})
-		if foundPromImg {
-			if id, ok := promImg[id].(string); ok {
-				u, err := extractUUIDFromString(id)
-				if err != nil {
-					logger.Infof(tid, uuid, "Cannot find promotional image: %v. Skipping expanding promotional image", err.Error())
-					foundPromImg = false
-				} else {
-					schema.put(promotionalImage, u)
-				}
-			} else {
-				logger.Info(tid, uuid, "Promotional image is missing the id field. Skipping expanding promotional image")
-				foundPromImg = false
+		for _, name := range sortedKeys(altImg) {
+			aiID, ok := altImageID(altImg[name])
+			if !ok {
+				logger.Infof(tid, uuid, "Alternative image %s is missing the id field. Skipping expanding it", name)
+				continue
 			}
-		} else {
-			logger.Info(tid, uuid, "Cannot find promotional image. Skipping expanding promotional image")
+			u, err := extractUUIDFromString(aiID)
+			if err != nil {
+				logger.Infof(tid, uuid, "Cannot find alternative image %s: %v. Skipping expanding it", name, err.Error())
+				report.add(aiID, altImages+"."+name, reasonInvalidUUID)
+				continue
+			}
+			schema.put(altImages+"."+name, u)
+			foundAltImg = true
 		}
+	} else {
+		logger.Info(tid, uuid, "Cannot find alternative images. Skipping expanding alternative images")
 	}
 
-	if !foundMainImg && !foundEmbedded && !foundPromImg {
-		logger.Infof(tid, uuid, "No main image or promotional image or embedded content to expand for supplied content %s", uuid)
+	if !foundMainImg && !foundEmbedded && !foundAltImg {
+		logger.Infof(tid, uuid, "No main image or alternative images or embedded content to expand for supplied content %s", uuid)
 		return nil
 	}
 
 	return schema
 }
 
-func (u *ContentUnroller) unrollLeadImages(cc Content, tid string, uuid string) ([]Content, bool) {
+func (u *ContentUnroller) unrollLeadImages(ctx context.Context, cc Content, tid string, uuid string, report *unrollReport) ([]Content, bool) {
 	images, foundLeadImages := cc[leadImages].([]interface{})
 	if !foundLeadImages {
 		logger.Info(tid, uuid, "No lead images to expand for supplied content")
@@ -158,24 +224,30 @@ func (u *ContentUnroller) unrollLeadImages(cc Content, tid string, uuid string)
 		return nil, false
 	}
 	schema := make(ContentSchema)
-	for _, item := range images {
+	for i, item := range images {
 		li := item.(map[string]interface{})
-		uuid, err := extractUUIDFromString(li[id].(string))
+		liID, _ := li[id].(string)
+		uuid, err := extractUUIDFromString(liID)
 		if err != nil {
-			logger.Infof(tid, uuid, "Error while getting UUID for %s: %v", li[id].(string), err.Error())
+			logger.Infof(tid, uuid, "Error while getting UUID for %s: %v", liID, err.Error())
+			report.add(liID, indexedPath(leadImages, i), reasonInvalidUUID)
 			continue
 		}
 		li[image] = uuid
 		schema.put(leadImages, uuid)
 	}
 
-	imgMap, err := u.reader.Get(schema.toArray(), tid)
+	logger.Debugf(tid, uuid, "Lead images schema: %v", schema)
+	imgMap, err := u.reader.Get(ctx, schema.toArray(), tid)
 	if err != nil {
 		logger.Errorf(tid, "Error while getting content for expanded images %s", err.Error())
 
 		// couldn't get the images so we have to delete the additional uuid field (previously added)
-		for _, li := range images {
+		for i, li := range images {
 			rawLi := li.(map[string]interface{})
+			if liUUID, ok := rawLi[image].(string); ok {
+				report.add(liUUID, indexedPath(leadImages, i), reasonBackendError)
+			}
 			delete(rawLi, image)
 		}
 
@@ -183,17 +255,31 @@ func (u *ContentUnroller) unrollLeadImages(cc Content, tid string, uuid string)
 	}
 
 	expLeadImages := []Content{}
-	for _, li := range images {
+	unresolvedMembers := make(map[string][]UnresolvedReference)
+	for i, li := range images {
 		rawLi := li.(map[string]interface{})
-		rawLiUUID := rawLi[image].(string)
 		liContent := fromMap(rawLi)
+		rawLiUUID, ok := rawLi[image].(string)
+		if !ok {
+			// the UUID of the lead image is invalid, so it is returned as it is
+			expLeadImages = append(expLeadImages, liContent)
+			continue
+		}
 		imageData, found := u.resolveContent(rawLiUUID, imgMap)
 		if !found {
 			logger.Infof(tid, uuid, "Missing image model %s. Returning only the id.", rawLiUUID)
+			report.add(rawLiUUID, indexedPath(leadImages, i), reasonNotFound)
 			delete(liContent, image)
 			expLeadImages = append(expLeadImages, liContent)
 			continue
 		}
+		// lead images share their models when they reference the same image, so the members are resolved only once
+		refs, resolved := unresolvedMembers[rawLiUUID]
+		if !resolved {
+			refs = u.resolveMembers(imageData, imgMap, tid, uuid)
+			unresolvedMembers[rawLiUUID] = refs
+		}
+		report.addNested(indexedPath(leadImages, i)+"."+image, refs)
 		liContent[image] = imageData
 		expLeadImages = append(expLeadImages, liContent)
 	}
@@ -202,59 +288,83 @@ func (u *ContentUnroller) unrollLeadImages(cc Content, tid string, uuid string)
 	return expLeadImages, true
 }
 
-func (u *ContentUnroller) unrollDynamicContent(cc Content, tid string, uuid string, getContentFromSourceFn ReaderFunc) ([]Content, bool) {
+func (u *ContentUnroller) unrollDynamicContent(ctx context.Context, cc Content, tid string, uuid string, getContentFromSourceFn ReaderFunc, report *unrollReport) ([]Content, bool) {
 	emContentUUIDs, foundEmbedded := u.extractEmbeddedContentByType(cc, []string{DynamicContentType}, tid, uuid)
 	if !foundEmbedded {
 		return nil, false
 	}
 
-	contentMap, err := getContentFromSourceFn(emContentUUIDs, tid)
+	contentMap, err := getContentFromSourceFn(ctx, emContentUUIDs, tid)
 	if err != nil {
 		logger.Errorf(tid, "Error while getting embedded dynamic content %s", err.Error())
+		for i, ec := range emContentUUIDs {
+			report.add(ec, indexedPath(embeds, i), reasonBackendError)
+		}
 		return nil, false
 	}
 
 	embedded := []Content{}
-	for _, ec := range emContentUUIDs {
+	for i, ec := range emContentUUIDs {
+		if _, found := contentMap[ec]; !found {
+			report.add(ec, indexedPath(embeds, i), reasonNotFound)
+		}
 		embedded = append(embedded, contentMap[ec])
 	}
 
 	return embedded, true
 }
 
-func (u *ContentUnroller) resolveModelsForSetsMembers(b ContentSchema, imgMap map[string]Content, tid string, uuid string) {
-	mainImageUUID := b.get(mainImage)
-	u.resolveImageSet(mainImageUUID, imgMap, tid, uuid)
-	for _, embeddedImgSet := range b.getAll(embeds) {
-		u.resolveImageSet(embeddedImgSet, imgMap, tid, uuid)
+// resolveModelsForSetsMembers resolves the members of the main image, embedded and alternative image sets. It returns
+// the references left unresolved within each of them, keyed by the image set UUID.
+func (u *ContentUnroller) resolveModelsForSetsMembers(b ContentSchema, imgMap map[string]Content, tid string, uuid string) map[string][]UnresolvedReference {
+	unresolved := make(map[string][]UnresolvedReference)
+	imgSetUUIDs := append([]string{b.get(mainImage)}, b.getAll(embeds)...)
+	for _, ai := range b.getAltImages() {
+		imgSetUUIDs = append(imgSetUUIDs, ai.uuid)
 	}
+	for _, imgSetUUID := range imgSetUUIDs {
+		if _, done := unresolved[imgSetUUID]; done || imgSetUUID == "" {
+			continue
+		}
+		unresolved[imgSetUUID] = u.resolveImageSet(imgSetUUID, imgMap, tid, uuid)
+	}
+	return unresolved
 }
 
-func (u *ContentUnroller) resolveImageSet(imageSetUUID string, imgMap map[string]Content, tid string, uuid string) {
+// resolveImageSet returns the references it couldn't resolve, relative to the image set.
+func (u *ContentUnroller) resolveImageSet(imageSetUUID string, imgMap map[string]Content, tid string, uuid string) []UnresolvedReference {
 	imageSet, found := u.resolveContent(imageSetUUID, imgMap)
 	if !found {
-		imgMap[imageSetUUID] = Content{id: createID(u.apiHost, "content", imageSetUUID)}
-		return
+		imgMap[imageSetUUID] = Content{id: u.createID(contentEndpoint, imageSetUUID)}
+		return []UnresolvedReference{{UUID: imageSetUUID, Reason: reasonNotFound}}
 	}
+	return u.resolveMembers(imageSet, imgMap, tid, uuid)
+}
 
+// resolveMembers merges the models of the members into an image set. Models which aren't image sets are left
+// untouched. It returns the members it couldn't resolve, relative to the image set.
+func (u *ContentUnroller) resolveMembers(imageSet Content, imgMap map[string]Content, tid string, uuid string) []UnresolvedReference {
+	var report unrollReport
 	rawMembers, found := imageSet[members]
 	if found {
 		membList, ok := rawMembers.([]interface{})
 		if !ok {
-			return
+			return nil
 		}
 
 		expMembers := []Content{}
-		for _, m := range membList {
+		for j, m := range membList {
 			mData := fromMap(m.(map[string]interface{}))
-			mID := mData[id].(string)
+			mID, _ := mData[id].(string)
 			mUUID, err := extractUUIDFromString(mID)
 			if err != nil {
 				logger.Infof(tid, uuid, "Error while extracting UUID from %s: %v", mID, err.Error())
+				report.add(mID, indexedPath(members, j), reasonInvalidUUID)
 				continue
 			}
 			mContent, found := u.resolveContent(mUUID, imgMap)
 			if !found {
+				report.add(mUUID, indexedPath(members, j), reasonNotFound)
 				expMembers = append(expMembers, mData)
 				continue
 			}
@@ -263,7 +373,7 @@ func (u *ContentUnroller) resolveImageSet(imageSetUUID string, imgMap map[string
 		}
 		imageSet[members] = expMembers
 	}
-
+	return report
 }
 
 func (u *ContentUnroller) resolveContent(uuid string, imgMap map[string]Content) (Content, bool) {
@@ -336,7 +446,7 @@ func (c Content) merge(src Content) {
 }
 
 func (u ContentSchema) put(key string, value string) {
-	if key != mainImage && key != promotionalImage && key != leadImages {
+	if key != mainImage && key != leadImages && !isAltImageKey(key) {
 		return
 	}
 	prev, found := u[key]
@@ -349,7 +459,7 @@ func (u ContentSchema) put(key string, value string) {
 }
 
 func (u ContentSchema) get(key string) string {
-	if _, found := u[key]; key != mainImage && key != promotionalImage || !found {
+	if _, found := u[key]; key != mainImage && !isAltImageKey(key) || !found {
 		return ""
 	}
 	return u[key][0]
@@ -374,6 +484,48 @@ func (u ContentSchema) getAll(key string) []string {
 	return u[key]
 }
 
+type altImageRef struct {
+	name string
+	uuid string
+}
+
+// getAltImages returns the alternative images, e.g. promotionalImage, sorted by name.
+func (u ContentSchema) getAltImages() []altImageRef {
+	var refs []altImageRef
+	for k, v := range u {
+		if isAltImageKey(k) && len(v) > 0 {
+			refs = append(refs, altImageRef{name: strings.TrimPrefix(k, altImages+"."), uuid: v[0]})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	return refs
+}
+
+func isAltImageKey(key string) bool {
+	return strings.HasPrefix(key, altImages+".")
+}
+
+// altImageID returns the id of an alternative image, given either as a model with an id or as a plain URL.
+func altImageID(ai interface{}) (string, bool) {
+	switch v := ai.(type) {
+	case map[string]interface{}:
+		id, ok := v[id].(string)
+		return id, ok
+	case string:
+		return v, v != ""
+	}
+	return "", false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
 func (u ContentSchema) toArray() (UUIDs []string) {
 	for _, v := range u {
 		UUIDs = append(UUIDs, v...)
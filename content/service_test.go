@@ -1,6 +1,7 @@
 package content
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"testing"
@@ -19,11 +20,11 @@ type ReaderMock struct {
 	mockGetInternal func(uuids []string, tid string) (map[string]Content, error)
 }
 
-func (rm *ReaderMock) Get(c []string, tid string) (map[string]Content, error) {
+func (rm *ReaderMock) Get(ctx context.Context, c []string, tid string) (map[string]Content, error) {
 	return rm.mockGet(c, tid)
 }
 
-func (rm *ReaderMock) GetInternal(c []string, tid string) (map[string]Content, error) {
+func (rm *ReaderMock) GetInternal(ctx context.Context, c []string, tid string) (map[string]Content, error) {
 	return rm.mockGetInternal(c, tid)
 }
 
@@ -50,8 +51,8 @@ func TestUnrollContent(t *testing.T) {
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	actual := cu.UnrollContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	actual := cu.UnrollContent(context.Background(), req)
 	assert.NoError(t, actual.err, "Should not get an error when expanding images")
 
 	actualJSON, err := json.Marshal(actual.uc)
@@ -64,8 +65,8 @@ func TestUnrollContent_NilSchema(t *testing.T) {
 	err := json.Unmarshal([]byte(InvalidBodyRequest), &c)
 	assert.NoError(t, err, "Cannot build json body")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	actual := cu.UnrollContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	actual := cu.UnrollContent(context.Background(), req)
 	actualJSON, err := json.Marshal(actual.uc)
 
 	assert.JSONEq(t, InvalidBodyRequest, string(actualJSON))
@@ -86,8 +87,8 @@ func TestUnrollContent_ErrorExpandingFromContentStore(t *testing.T) {
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	actual := cu.UnrollContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	actual := cu.UnrollContent(context.Background(), req)
 
 	actualJSON, err := json.Marshal(actual.uc)
 	assert.JSONEq(t, string(fileBytes), string(actualJSON))
@@ -120,8 +121,8 @@ func TestUnrollContent_SkipPromotionalImageWhenIdIsMissing(t *testing.T) {
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	actual := cu.UnrollContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	actual := cu.UnrollContent(context.Background(), req)
 
 	assert.NoError(t, actual.err, "Should not get an error when expanding images")
 	assert.Equal(t, expectedAltImages, actual.uc[altImages])
@@ -153,8 +154,8 @@ func TestUnrollContent_SkipPromotionalImageWhenUUIDIsInvalid(t *testing.T) {
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	actual := cu.UnrollContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	actual := cu.UnrollContent(context.Background(), req)
 
 	assert.NoError(t, actual.err, "Should not get an error when expanding images")
 	assert.Equal(t, expectedAltImages, actual.uc[altImages])
@@ -181,8 +182,8 @@ func TestUnrollContent_EmbeddedContentSkippedWhenMissingBodyXML(t *testing.T) {
 	err = json.Unmarshal(fileBytes, &c)
 	c[bodyXML] = "invalid body"
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	res := cu.UnrollContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	res := cu.UnrollContent(context.Background(), req)
 	assert.NoError(t, res.err, "Should not receive error when body cannot be parsed.")
 	assert.Nil(t, res.uc["embeds"], "Response should not contain embeds field")
 }
@@ -218,8 +219,8 @@ func TestUnrollInternalContent(t *testing.T) {
 	expected, err := ioutil.ReadFile("../test-resources/internalcontent-valid-response.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	actual := cu.UnrollInternalContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	actual := cu.UnrollInternalContent(context.Background(), req)
 	assert.NoError(t, actual.err, "Should not receive error for expanding internal content")
 
 	actualJSON, err := json.Marshal(actual.uc)
@@ -252,8 +253,8 @@ func TestUnrollInternalContent_LeadImagesSkippedWhenReadingError(t *testing.T) {
 	expected, err := ioutil.ReadFile("../test-resources/internalcontent-valid-response-no-lead-images.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	actual := cu.UnrollInternalContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	actual := cu.UnrollInternalContent(context.Background(), req)
 	assert.NoError(t, actual.err, "Should not receive error for expanding internal content")
 
 	actualJSON, err := json.Marshal(actual.uc)
@@ -286,8 +287,8 @@ func TestUnrollInternalContent_DynamicContentSkippedWhenReadingError(t *testing.
 	expected, err := ioutil.ReadFile("../test-resources/internalcontent-valid-response-no-dynamic-content.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
-	actual := cu.UnrollInternalContent(req)
+	req := UnrollEvent{c, "tid_sample", "sample_uuid", nil}
+	actual := cu.UnrollInternalContent(context.Background(), req)
 	assert.NoError(t, actual.err, "Should not receive error for expanding internal content")
 
 	actualJSON, err := json.Marshal(actual.uc)
@@ -299,3 +300,164 @@ func TestExtractIDFromURL(t *testing.T) {
 	assert.NoError(t, err, "Test should not return error")
 	assert.Equal(t, expectedId, actual, "Response id should be equal")
 }
+
+func TestUnrollContent_ReportsUnresolvedReferences(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				models := imageSetModels()
+				return map[string]Content{imageSetUUID: models[imageSetUUID]}, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := Content{
+		id:        "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		mainImage: map[string]interface{}{id: "http://api.ft.com/content/" + imageSetUUID},
+		bodyXML: `<body><ft-content type="http://www.ft.com/ontology/content/ImageSet" url="http://api.ft.com/content/` +
+			otherUUID + `" data-embedded="true"></ft-content></body>`,
+		altImages: map[string]interface{}{promotionalImage: map[string]interface{}{id: "http://api.ft.com/content/not-uuid"}},
+	}
+	res := cu.UnrollContent(context.Background(), UnrollEvent{article, "tid_sample", "22c0d426-1466-11e7-b0c1-37e417ee6c76", nil})
+
+	assert.NoError(t, res.err)
+	assert.Equal(t, []UnresolvedReference{
+		{UUID: "http://api.ft.com/content/not-uuid", Path: "alternativeImages.promotionalImage", Reason: reasonInvalidUUID},
+		{UUID: memberUUID, Path: "mainImage.members[0]", Reason: reasonNotFound},
+		{UUID: otherUUID, Path: "embeds[0]", Reason: reasonNotFound},
+	}, res.unresolved)
+}
+
+func TestUnrollInternalContent_ReportsUnresolvedLeadImages(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				return map[string]Content{}, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := Content{
+		id: "http://www.ft.com/thing/5010e2e4-09bd-11e7-97d1-5e720a26771b",
+		leadImages: []interface{}{
+			map[string]interface{}{id: "http://api.ft.com/content/" + memberUUID},
+			map[string]interface{}{id: "http://api.ft.com/content/not-uuid"},
+		},
+	}
+	res := cu.UnrollInternalContent(context.Background(), UnrollEvent{article, "tid_sample", "5010e2e4-09bd-11e7-97d1-5e720a26771b", nil})
+
+	assert.NoError(t, res.err)
+	assert.Len(t, res.uc[leadImages], 2, "Lead images with an invalid UUID should be kept")
+	assert.Equal(t, []UnresolvedReference{
+		{UUID: "http://api.ft.com/content/not-uuid", Path: "leadImages[1]", Reason: reasonInvalidUUID},
+		{UUID: memberUUID, Path: "leadImages[0]", Reason: reasonNotFound},
+	}, res.unresolved)
+}
+
+func TestUnrollInternalContent_ReportsBackendErrors(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGetInternal: func(c []string, tid string) (map[string]Content, error) {
+				return nil, errors.New("Service unavailable")
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := Content{
+		id: "http://www.ft.com/thing/5010e2e4-09bd-11e7-97d1-5e720a26771b",
+		bodyXML: `<body><ft-content type="http://www.ft.com/ontology/content/DynamicContent" url="http://api.ft.com/content/` +
+			otherUUID + `" data-embedded="true"></ft-content></body>`,
+	}
+	res := cu.UnrollInternalContent(context.Background(), UnrollEvent{article, "tid_sample", "5010e2e4-09bd-11e7-97d1-5e720a26771b", nil})
+
+	assert.NoError(t, res.err)
+	assert.Equal(t, []UnresolvedReference{{UUID: otherUUID, Path: "embeds[0]", Reason: reasonBackendError}}, res.unresolved)
+}
+
+func TestUnrollContent_ExpandsAllAlternativeImages(t *testing.T) {
+	missingUUID := "0261ea4a-1474-11e7-1e92-847abda1ac65"
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				assert.ElementsMatch(t, []string{imageSetUUID, otherUUID, missingUUID}, c)
+				return imageSetModels(), nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	socialImage := map[string]interface{}{id: "http://api.ft.com/content/" + missingUUID}
+	alternativeImages := map[string]interface{}{
+		promotionalImage: map[string]interface{}{id: "http://api.ft.com/content/" + imageSetUUID},
+		"squareImage":    "http://api.ft.com/content/" + otherUUID,
+		"socialImage":    socialImage,
+		"wideImage":      nil,
+	}
+	article := Content{
+		id:        "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		altImages: alternativeImages,
+	}
+	res := cu.UnrollContent(context.Background(), UnrollEvent{article, "tid_sample", "22c0d426-1466-11e7-b0c1-37e417ee6c76", nil})
+	assert.NoError(t, res.err)
+
+	expAltImages := res.uc[altImages].(map[string]interface{})
+	pi := expAltImages[promotionalImage].(Content)
+	assert.Equal(t, ImageSetType, pi["type"])
+	assert.Equal(t, "http://www.ft.com/thing/"+memberUUID, pi[members].([]Content)[0][id], "Members of alternative image sets should be resolved")
+	assert.Equal(t, "http://www.ft.com/thing/"+otherUUID, expAltImages["squareImage"].(Content)[id])
+	assert.Equal(t, socialImage, expAltImages["socialImage"], "Missing alternative images should be left as they are")
+	assert.Nil(t, expAltImages["wideImage"])
+	assert.Equal(t, []UnresolvedReference{{UUID: missingUUID, Path: "alternativeImages.socialImage", Reason: reasonNotFound}}, res.unresolved)
+
+	assert.IsType(t, map[string]interface{}{}, alternativeImages[promotionalImage], "The supplied article should not be modified")
+	assert.Equal(t, "http://api.ft.com/content/"+otherUUID, alternativeImages["squareImage"])
+}
+
+func TestUnrollInternalContent_ResolvesMembersOfLeadImageSets(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				return imageSetModels(), nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := Content{
+		id: "http://www.ft.com/thing/5010e2e4-09bd-11e7-97d1-5e720a26771b",
+		leadImages: []interface{}{
+			map[string]interface{}{id: "http://api.ft.com/content/" + imageSetUUID, "type": "square"},
+			map[string]interface{}{id: "http://api.ft.com/content/" + imageSetUUID, "type": "wide"},
+		},
+	}
+	res := cu.UnrollInternalContent(context.Background(), UnrollEvent{article, "tid_sample", "5010e2e4-09bd-11e7-97d1-5e720a26771b", nil})
+	assert.NoError(t, res.err)
+
+	for _, li := range res.uc[leadImages].([]Content) {
+		imgSet := li[image].(Content)
+		assert.Equal(t, []Content{{id: "http://www.ft.com/thing/" + memberUUID}}, imgSet[members])
+	}
+	assert.Empty(t, res.unresolved)
+}
+
+func TestUnrollInternalContent_ReportsUnresolvedMembersOfLeadImageSets(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				return map[string]Content{imageSetUUID: imageSetModels()[imageSetUUID]}, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	article := Content{
+		id:         "http://www.ft.com/thing/5010e2e4-09bd-11e7-97d1-5e720a26771b",
+		leadImages: []interface{}{map[string]interface{}{id: "http://api.ft.com/content/" + imageSetUUID}},
+	}
+	res := cu.UnrollInternalContent(context.Background(), UnrollEvent{article, "tid_sample", "5010e2e4-09bd-11e7-97d1-5e720a26771b", nil})
+	assert.NoError(t, res.err)
+	assert.Equal(t, []UnresolvedReference{{UUID: memberUUID, Path: "leadImages[0].image.members[0]", Reason: reasonNotFound}}, res.unresolved)
+}
@@ -21,6 +21,6 @@ func extractUUIDFromString(url string) (string, error) {
 	return "", errors.Errorf("Cannot extract UUID from %s", url)
 }
 
-func createID(APIHost string, handlerPath string, uuid string) string {
-	return "http://" + APIHost + "/" + handlerPath + "/" + uuid
+func createID(APIScheme string, APIHost string, handlerPath string, uuid string) string {
+	return APIScheme + "://" + APIHost + "/" + handlerPath + "/" + uuid
 }
@@ -0,0 +1,117 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+)
+
+const (
+	contentURI = "contentUri"
+	payload    = "payload"
+)
+
+// UnrollWorker consumes content publication events, unrolls their payload and produces the unrolled events.
+// The events that can't be unrolled are sent unmodified to DeadLetter, when set, otherwise they're dropped. They're
+// never produced as they are, as the consumers of the unrolled events couldn't tell them apart.
+type UnrollWorker struct {
+	Service    Unroller
+	Consumer   MessageConsumer
+	Producer   MessageProducer
+	DeadLetter MessageProducer
+}
+
+// Start consumes messages until Stop is called.
+func (uw *UnrollWorker) Start() {
+	uw.Consumer.Consume(uw.handleMessage)
+}
+
+func (uw *UnrollWorker) Stop() {
+	uw.Consumer.Stop()
+}
+
+func (uw *UnrollWorker) handleMessage(m Message) {
+	tid := m.Headers[transactionidutils.TransactionIDHeader]
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(m.Body), &event); err != nil {
+		logger.Errorf(tid, "Cannot parse publication event: %v", err)
+		uw.sendFailed(m, tid)
+		return
+	}
+
+	if err := uw.unrollEvent(event, tid); err != nil {
+		logger.Errorf(tid, "Cannot unroll publication event for %v: %v", event[contentURI], err)
+		uw.sendFailed(m, tid)
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf(tid, "Cannot marshal unrolled publication event: %v", err)
+		uw.sendFailed(m, tid)
+		return
+	}
+
+	if err = uw.Producer.SendMessage(Message{Headers: m.Headers, Body: string(body)}); err != nil {
+		logger.Errorf(tid, "Cannot send unrolled publication event for %v: %v", event[contentURI], err)
+		uw.sendFailed(m, tid)
+	}
+}
+
+// sendFailed sends the consumed message, unmodified, to the dead letter topic. It's dropped when there's none.
+func (uw *UnrollWorker) sendFailed(m Message, tid string) {
+	if uw.DeadLetter == nil {
+		logger.Errorf(tid, "Dropping publication event that failed to be unrolled")
+		return
+	}
+	if err := uw.DeadLetter.SendMessage(m); err != nil {
+		logger.Errorf(tid, "Cannot send publication event that failed to be unrolled to the dead letter topic: %v", err)
+	}
+}
+
+// unrollEvent replaces the payload of the event with its unrolled version. Events without a payload
+// (e.g. deletes) or without anything to unroll are left unchanged.
+func (uw *UnrollWorker) unrollEvent(event map[string]interface{}, tid string) error {
+	p, found := event[payload].(map[string]interface{})
+	if !found {
+		return nil
+	}
+
+	article := Content(p)
+	id, ok := article[id].(string)
+	if !ok {
+		return nil
+	}
+	uuid, err := extractUUIDFromString(id)
+	if err != nil {
+		return err
+	}
+	ue := UnrollEvent{article, tid, uuid, nil}
+
+	uri, _ := event[contentURI].(string)
+	var res UnrollResult
+	if strings.Contains(uri, "/internalcontent/") {
+		if !validateInternalContent(article) {
+			return nil
+		}
+		res = uw.Service.UnrollInternalContent(context.Background(), ue)
+	} else {
+		if !validateContent(article) {
+			return nil
+		}
+		res = uw.Service.UnrollContent(context.Background(), ue)
+	}
+	if res.err != nil {
+		return res.err
+	}
+
+	if len(res.unresolved) > 0 {
+		logger.Warnf(tid, uuid, "Unresolved references in %s: %s", uri, formatUnresolvedHeader(res.unresolved))
+	}
+	logger.Infof(tid, uuid, "Unrolled publication event for %s", uri)
+	event[payload] = res.uc
+	return nil
+}
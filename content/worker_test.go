@@ -0,0 +1,154 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const publicationEvent = `{
+	"contentUri": "http://methode-article-mapper.svc.ft.com/content/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+	"lastModified": "2017-03-31T15:42:28.504Z",
+	"payload": {
+		"id": "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		"mainImage": {"id": "http://api.ft.com/content/639cd952-149f-11e7-2ea7-a07ecd9ac73f"}
+	}
+}`
+
+func startWorkerForTest(cu Unroller) (*UnrollWorker, *InMemoryQueue, *InMemoryQueue) {
+	in := NewInMemoryQueue(10)
+	out := NewInMemoryQueue(10)
+	w := &UnrollWorker{Service: cu, Consumer: in, Producer: out}
+	go w.Start()
+	return w, in, out
+}
+
+func receiveMessage(t *testing.T, q *InMemoryQueue) (Message, bool) {
+	select {
+	case m := <-q.messages:
+		return m, true
+	case <-time.After(time.Second):
+		return Message{}, false
+	}
+}
+
+func TestUnrollWorker_ProducesUnrolledContent(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			assert.Equal(t, "tid_test", req.tid)
+			assert.Equal(t, "22c0d426-1466-11e7-b0c1-37e417ee6c76", req.uuid)
+			uc := req.c.clone()
+			uc[mainImage] = Content{id: "http://www.ft.com/thing/639cd952-149f-11e7-2ea7-a07ecd9ac73f", "type": ImageSetType}
+			return UnrollResult{uc, nil, nil}
+		},
+	}
+	w, in, out := startWorkerForTest(&cu)
+	defer w.Stop()
+
+	headers := map[string]string{"X-Request-Id": "tid_test", "Message-Id": "6f2c6f43-9a4c-4b4e-8a62-6a7a8f3c9d1e"}
+	assert.NoError(t, in.SendMessage(Message{Headers: headers, Body: publicationEvent}))
+
+	m, found := receiveMessage(t, out)
+	assert.True(t, found, "Unrolled event should be produced")
+	assert.Equal(t, headers, m.Headers)
+
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(m.Body), &event))
+	assert.Equal(t, "2017-03-31T15:42:28.504Z", event["lastModified"])
+	p := event["payload"].(map[string]interface{})
+	assert.Equal(t, ImageSetType, p[mainImage].(map[string]interface{})["type"])
+}
+
+func TestUnrollWorker_UsesInternalUnrollingForInternalContent(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollInternalContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			uc := req.c.clone()
+			uc[leadImages] = []Content{}
+			return UnrollResult{uc, nil, nil}
+		},
+	}
+	w, in, out := startWorkerForTest(&cu)
+	defer w.Stop()
+
+	body := `{"contentUri": "http://methode-article-internal-components-mapper.svc.ft.com/internalcontent/5010e2e4-09bd-11e7-97d1-5e720a26771b",
+		"payload": {"id": "http://www.ft.com/thing/5010e2e4-09bd-11e7-97d1-5e720a26771b", "leadImages": [{"id": "89f194c8-13bc-11e7-80f4-13e067d5072c"}]}}`
+	assert.NoError(t, in.SendMessage(Message{Body: body}))
+
+	m, found := receiveMessage(t, out)
+	assert.True(t, found, "Unrolled event should be produced")
+	assert.Contains(t, m.Body, `"leadImages":[]`)
+}
+
+func TestUnrollWorker_ForwardsEventsWithoutPayload(t *testing.T) {
+	w, in, out := startWorkerForTest(&ContentUnrollerMock{})
+	defer w.Stop()
+
+	body := `{"contentUri": "http://methode-article-mapper.svc.ft.com/content/22c0d426-1466-11e7-b0c1-37e417ee6c76", "payload": null}`
+	assert.NoError(t, in.SendMessage(Message{Body: body}))
+
+	m, found := receiveMessage(t, out)
+	assert.True(t, found, "Delete event should be forwarded")
+	assert.JSONEq(t, body, m.Body)
+}
+
+func TestUnrollWorker_DropsEventsThatCannotBeUnrolled(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{nil, errors.New("Error while unrolling content"), nil}
+		},
+	}
+	w, in, out := startWorkerForTest(&cu)
+	defer w.Stop()
+
+	assert.NoError(t, in.SendMessage(Message{Body: "invalid json"}))
+	assert.NoError(t, in.SendMessage(Message{Body: publicationEvent}))
+
+	_, found := receiveMessage(t, out)
+	assert.False(t, found, "Events that weren't unrolled shouldn't be produced")
+}
+
+func TestUnrollWorker_SendsEventsThatCannotBeUnrolledToDeadLetter(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(ctx context.Context, req UnrollEvent) UnrollResult {
+			return UnrollResult{nil, errors.New("Error while unrolling content"), nil}
+		},
+	}
+	in := NewInMemoryQueue(10)
+	out := NewInMemoryQueue(10)
+	dlq := NewInMemoryQueue(10)
+	w := &UnrollWorker{Service: &cu, Consumer: in, Producer: out, DeadLetter: dlq}
+	go w.Start()
+	defer w.Stop()
+
+	for _, body := range []string{"invalid json", publicationEvent} {
+		m := Message{Headers: map[string]string{"X-Request-Id": "tid_test"}, Body: body}
+		assert.NoError(t, in.SendMessage(m))
+
+		dead, found := receiveMessage(t, dlq)
+		assert.True(t, found, "Failed event should be sent to the dead letter topic")
+		assert.Equal(t, m, dead, "Failed event should be sent unmodified")
+	}
+	_, found := receiveMessage(t, out)
+	assert.False(t, found, "Failed event shouldn't be produced to the output topic")
+}
+
+func TestFTMessage_RoundTrip(t *testing.T) {
+	m := Message{
+		Headers: map[string]string{"Message-Id": "6f2c6f43-9a4c-4b4e-8a62-6a7a8f3c9d1e", "X-Request-Id": "tid_test"},
+		Body:    `{"payload": {}}`,
+	}
+
+	raw := buildFTMessage(m)
+	assert.Equal(t, "FTMSG/1.0\r\nMessage-Id: 6f2c6f43-9a4c-4b4e-8a62-6a7a8f3c9d1e\r\nX-Request-Id: tid_test\r\n\r\n{\"payload\": {}}", raw)
+	assert.Equal(t, m, parseFTMessage(raw))
+}
+
+func TestFTMessage_ParsesBodyWithoutHeaders(t *testing.T) {
+	m := parseFTMessage(`{"payload": {}}`)
+	assert.Equal(t, `{"payload": {}}`, m.Body)
+	assert.Empty(t, m.Headers)
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"expvar"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Financial-Times/content-unroller/content"
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	"github.com/Financial-Times/service-status-go/gtg"
 	"github.com/Financial-Times/service-status-go/httphandlers"
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	cli "github.com/jawher/mow.cli"
@@ -55,23 +66,463 @@ func main() {
 		Desc:   "/internalcontent path",
 		EnvVar: "INTERNAL_CONTENT_PATH",
 	})
+	previewStoreApplicationName := app.String(cli.StringOpt{
+		Name:   "previewSourceAppName",
+		Value:  "content-public-read-preview",
+		Desc:   "Draft content read app used by the preview endpoints",
+		EnvVar: "PREVIEW_STORE_APP_NAME",
+	})
+	previewStoreHost := app.String(cli.StringOpt{
+		Name:   "previewStoreHost",
+		Value:  "",
+		Desc:   "Draft content source hostname. The preview endpoints are disabled when empty",
+		EnvVar: "PREVIEW_STORE_HOST",
+	})
+	previewContentPathEndpoint := app.String(cli.StringOpt{
+		Name:   "previewContentPathEndpoint",
+		Value:  "/content-preview",
+		Desc:   "/content path of the draft content source",
+		EnvVar: "PREVIEW_CONTENT_PATH",
+	})
+	previewInternalContentPathEndpoint := app.String(cli.StringOpt{
+		Name:   "previewInternalContentPathEndpoint",
+		Value:  "/internalcontent-preview",
+		Desc:   "/internalcontent path of the draft content source",
+		EnvVar: "PREVIEW_INTERNAL_CONTENT_PATH",
+	})
 	apiHost := app.String(cli.StringOpt{
 		Name:   "apiHost",
 		Value:  "test.api.ft.com",
 		Desc:   "API host to use for URLs in responses",
 		EnvVar: "API_HOST",
 	})
+	apiScheme := app.String(cli.StringOpt{
+		Name:   "apiScheme",
+		Value:  "http",
+		Desc:   "Scheme to use for URLs in responses",
+		EnvVar: "API_SCHEME",
+	})
+	canonicalIDs := app.Bool(cli.BoolOpt{
+		Name:   "canonicalIDs",
+		Value:  false,
+		Desc:   "Rewrite the id, apiUrl and member ids of every expanded model to the apiHost based form",
+		EnvVar: "CANONICAL_IDS",
+	})
+	logLevel := app.String(cli.StringOpt{
+		Name:   "logLevel",
+		Value:  "info",
+		Desc:   "Log level (debug, info, warning, error), changeable at runtime through /__log-level",
+		EnvVar: "LOG_LEVEL",
+	})
+	workerMode := app.Bool(cli.BoolOpt{
+		Name:   "workerMode",
+		Value:  false,
+		Desc:   "Consume content publication events, unroll them and produce them to the output topic",
+		EnvVar: "WORKER_MODE",
+	})
+	kafkaProxyAddress := app.String(cli.StringOpt{
+		Name:   "kafkaProxyAddress",
+		Value:  "http://localhost:8080/__kafka-rest-proxy",
+		Desc:   "Address of the kafka REST proxy used in worker mode",
+		EnvVar: "KAFKA_PROXY_ADDR",
+	})
+	consumerGroup := app.String(cli.StringOpt{
+		Name:   "consumerGroup",
+		Value:  AppCode,
+		Desc:   "Kafka consumer group used in worker mode",
+		EnvVar: "CONSUMER_GROUP",
+	})
+	consumerTopic := app.String(cli.StringOpt{
+		Name:   "consumerTopic",
+		Value:  "PostPublicationEvents",
+		Desc:   "Topic with the content publication events to unroll",
+		EnvVar: "CONSUMER_TOPIC",
+	})
+	producerTopic := app.String(cli.StringOpt{
+		Name:   "producerTopic",
+		Value:  "UnrolledPublicationEvents",
+		Desc:   "Topic the unrolled publication events are written to",
+		EnvVar: "PRODUCER_TOPIC",
+	})
+	deadLetterTopic := app.String(cli.StringOpt{
+		Name:   "deadLetterTopic",
+		Value:  "",
+		Desc:   "Topic the publication events that can't be unrolled are written to, unmodified. They're dropped when empty",
+		EnvVar: "DEAD_LETTER_TOPIC",
+	})
+	invalidationTopic := app.String(cli.StringOpt{
+		Name:   "invalidationTopic",
+		Value:  "",
+		Desc:   "Topic with content notifications used to evict cached models. Disabled when empty",
+		EnvVar: "INVALIDATION_TOPIC",
+	})
+	instanceName := app.String(cli.StringOpt{
+		Name:   "instanceName",
+		Value:  "",
+		Desc:   "Name of the instance, stable across restarts (e.g. the StatefulSet pod name), naming its consumer group of the invalidation topic. The hostname when empty",
+		EnvVar: "INSTANCE_NAME",
+	})
+	contentCacheTTL := app.String(cli.StringOpt{
+		Name:   "contentCacheTTL",
+		Value:  "0s",
+		Desc:   "How long models read from the content store are cached (e.g. 1m). Caching is disabled when 0",
+		EnvVar: "CONTENT_CACHE_TTL",
+	})
+	contentCacheSize := app.Int(cli.IntOpt{
+		Name:   "contentCacheSize",
+		Value:  10000,
+		Desc:   "Maximum number of cached models",
+		EnvVar: "CONTENT_CACHE_SIZE",
+	})
+	responseCacheTTL := app.String(cli.StringOpt{
+		Name:   "responseCacheTTL",
+		Value:  "0s",
+		Desc:   "How long unrolled responses are cached for identical articles (e.g. 30s). Caching is disabled when 0",
+		EnvVar: "RESPONSE_CACHE_TTL",
+	})
+	responseCacheSize := app.Int(cli.IntOpt{
+		Name:   "responseCacheSize",
+		Value:  1000,
+		Desc:   "Maximum number of cached unrolled responses",
+		EnvVar: "RESPONSE_CACHE_SIZE",
+	})
+	sourcesConfig := app.String(cli.StringOpt{
+		Name:   "sourcesConfig",
+		Value:  "",
+		Desc:   "JSON file with the content sources and the routing rules between them. Only contentStoreHost is used when empty",
+		EnvVar: "SOURCES_CONFIG",
+	})
+	thingsApplicationName := app.String(cli.StringOpt{
+		Name:   "thingsAppName",
+		Value:  "public-things-api",
+		Desc:   "Things app used to expand brands and annotations",
+		EnvVar: "THINGS_APP_NAME",
+	})
+	thingsHost := app.String(cli.StringOpt{
+		Name:   "thingsHost",
+		Value:  "",
+		Desc:   "Things app hostname. Brands and annotations are not expanded when empty",
+		EnvVar: "THINGS_HOST",
+	})
+	thingsPathEndpoint := app.String(cli.StringOpt{
+		Name:   "thingsPathEndpoint",
+		Value:  "/things",
+		Desc:   "/things path",
+		EnvVar: "THINGS_PATH",
+	})
+	conceptCacheTTL := app.String(cli.StringOpt{
+		Name:   "conceptCacheTTL",
+		Value:  "0s",
+		Desc:   "How long concepts read from the things app are cached (e.g. 10m). Caching is disabled when 0",
+		EnvVar: "CONCEPT_CACHE_TTL",
+	})
+	conceptCacheSize := app.Int(cli.IntOpt{
+		Name:   "conceptCacheSize",
+		Value:  10000,
+		Desc:   "Maximum number of cached concepts",
+		EnvVar: "CONCEPT_CACHE_SIZE",
+	})
+	recordDir := app.String(cli.StringOpt{
+		Name:   "recordDir",
+		Value:  "",
+		Desc:   "Directory every content store request and response is recorded to, for replaying them later. Disabled when empty",
+		EnvVar: "RECORD_DIR",
+	})
+	requestTimeout := app.String(cli.StringOpt{
+		Name:   "requestTimeout",
+		Value:  "10s",
+		Desc:   "Time budget for unrolling a request, can be shortened per request with the X-Request-Timeout header. No budget when 0",
+		EnvVar: "REQUEST_TIMEOUT",
+	})
 
-	app.Action = func() {
-		httpClient := &http.Client{
-			Timeout: 10 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConnsPerHost: 100,
-				DialContext: (&net.Dialer{
-					KeepAlive: 30 * time.Second,
-				}).DialContext,
-			},
+	readTimeout := app.String(cli.StringOpt{
+		Name:   "readTimeout",
+		Value:  "10s",
+		Desc:   "Maximum duration for reading a request, including its body. No timeout when 0",
+		EnvVar: "READ_TIMEOUT",
+	})
+	writeTimeout := app.String(cli.StringOpt{
+		Name:   "writeTimeout",
+		Value:  "15s",
+		Desc:   "Maximum duration for writing a response, from the end of the request headers. Should exceed requestTimeout. No timeout when 0",
+		EnvVar: "WRITE_TIMEOUT",
+	})
+	idleTimeout := app.String(cli.StringOpt{
+		Name:   "idleTimeout",
+		Value:  "60s",
+		Desc:   "Maximum duration an idle keep-alive connection is kept open. No timeout when 0",
+		EnvVar: "IDLE_TIMEOUT",
+	})
+	shutdownDelay := app.String(cli.StringOpt{
+		Name:   "shutdownDelay",
+		Value:  "5s",
+		Desc:   "How long requests are still accepted after /__gtg starts failing on SIGTERM, for the load balancer to notice",
+		EnvVar: "SHUTDOWN_DELAY",
+	})
+	shutdownGracePeriod := app.String(cli.StringOpt{
+		Name:   "shutdownGracePeriod",
+		Value:  "20s",
+		Desc:   "Maximum time given to the in-flight requests and the messages being handled to finish on shutdown",
+		EnvVar: "SHUTDOWN_GRACE_PERIOD",
+	})
+
+	httpClientTimeout := app.String(cli.StringOpt{
+		Name:   "httpClientTimeout",
+		Value:  "10s",
+		Desc:   "Overall timeout of the requests to the backends. No timeout when 0",
+		EnvVar: "HTTP_CLIENT_TIMEOUT",
+	})
+	contentReadTimeout := app.String(cli.StringOpt{
+		Name:   "contentReadTimeout",
+		Value:  "",
+		Desc:   "Overrides httpClientTimeout for the content store /content reads",
+		EnvVar: "CONTENT_READ_TIMEOUT",
+	})
+	internalContentReadTimeout := app.String(cli.StringOpt{
+		Name:   "internalContentReadTimeout",
+		Value:  "",
+		Desc:   "Overrides httpClientTimeout for the content store /internalcontent reads",
+		EnvVar: "INTERNAL_CONTENT_READ_TIMEOUT",
+	})
+	dialTimeout := app.String(cli.StringOpt{
+		Name:   "dialTimeout",
+		Value:  "0s",
+		Desc:   "Timeout for establishing connections to the backends. No timeout when 0",
+		EnvVar: "HTTP_DIAL_TIMEOUT",
+	})
+	keepAlive := app.String(cli.StringOpt{
+		Name:   "keepAlive",
+		Value:  "30s",
+		Desc:   "Keep-alive period of the connections to the backends",
+		EnvVar: "HTTP_KEEP_ALIVE",
+	})
+	tlsHandshakeTimeout := app.String(cli.StringOpt{
+		Name:   "tlsHandshakeTimeout",
+		Value:  "0s",
+		Desc:   "Timeout for the TLS handshake with the backends. No timeout when 0",
+		EnvVar: "HTTP_TLS_HANDSHAKE_TIMEOUT",
+	})
+	responseHeaderTimeout := app.String(cli.StringOpt{
+		Name:   "responseHeaderTimeout",
+		Value:  "0s",
+		Desc:   "Timeout for receiving the response headers once a request is sent. No timeout when 0",
+		EnvVar: "HTTP_RESPONSE_HEADER_TIMEOUT",
+	})
+	idleConnTimeout := app.String(cli.StringOpt{
+		Name:   "idleConnTimeout",
+		Value:  "0s",
+		Desc:   "How long idle connections to the backends are kept in the pool. No limit when 0",
+		EnvVar: "HTTP_IDLE_CONN_TIMEOUT",
+	})
+	maxIdleConns := app.Int(cli.IntOpt{
+		Name:   "maxIdleConns",
+		Value:  0,
+		Desc:   "Maximum number of idle connections across all backends. No limit when 0",
+		EnvVar: "HTTP_MAX_IDLE_CONNS",
+	})
+	maxIdleConnsPerHost := app.Int(cli.IntOpt{
+		Name:   "maxIdleConnsPerHost",
+		Value:  100,
+		Desc:   "Maximum number of idle connections per backend host",
+		EnvVar: "HTTP_MAX_IDLE_CONNS_PER_HOST",
+	})
+	maxConnsPerHost := app.Int(cli.IntOpt{
+		Name:   "maxConnsPerHost",
+		Value:  0,
+		Desc:   "Maximum number of connections per backend host, including the ones in use. No limit when 0",
+		EnvVar: "HTTP_MAX_CONNS_PER_HOST",
+	})
+	http2 := app.Bool(cli.BoolOpt{
+		Name:   "http2",
+		Value:  false,
+		Desc:   "Use HTTP/2 with the backends supporting it",
+		EnvVar: "HTTP2_ENABLED",
+	})
+	httpProxy := app.String(cli.StringOpt{
+		Name:   "httpProxy",
+		Value:  "",
+		Desc:   "Proxy URL for the requests to the backends, or 'environment' to use HTTP_PROXY, HTTPS_PROXY and NO_PROXY. No proxy when empty",
+		EnvVar: "HTTP_PROXY_URL",
+	})
+	canaryUUID := app.String(cli.StringOpt{
+		Name:   "canaryUUID",
+		Value:  "",
+		Desc:   "UUID of a published article read from the content and internal content endpoints by the healthchecks. No canary checks when empty",
+		EnvVar: "CANARY_UUID",
+	})
+	canaryLatencyThreshold := app.String(cli.StringOpt{
+		Name:   "canaryLatencyThreshold",
+		Value:  "2s",
+		Desc:   "Latency over which the canary reads are reported as slow",
+		EnvVar: "CANARY_LATENCY_THRESHOLD",
+	})
+	healthCheckInterval := app.String(cli.StringOpt{
+		Name:   "healthCheckInterval",
+		Value:  "10s",
+		Desc:   "Interval of the background healthchecks served by /__health and /__gtg. The backends are checked on every request when 0",
+		EnvVar: "HEALTH_CHECK_INTERVAL",
+	})
+	rateLimit := app.String(cli.StringOpt{
+		Name:   "rateLimit",
+		Value:  "0",
+		Desc:   "Requests per second allowed to every client on the unrolling endpoints, e.g. 2.5. No limit when 0",
+		EnvVar: "RATE_LIMIT",
+	})
+	rateLimitBurst := app.Int(cli.IntOpt{
+		Name:   "rateLimitBurst",
+		Value:  20,
+		Desc:   "Requests a client can make at once above the rate limit",
+		EnvVar: "RATE_LIMIT_BURST",
+	})
+	maxInFlight := app.Int(cli.IntOpt{
+		Name:   "maxInFlight",
+		Value:  0,
+		Desc:   "Requests served at once on the unrolling endpoints across all clients. No limit when 0",
+		EnvVar: "MAX_IN_FLIGHT_REQUESTS",
+	})
+	rateLimitByHeaders := app.Bool(cli.BoolOpt{
+		Name:   "rateLimitByHeaders",
+		Value:  true,
+		Desc:   "Identify the unauthenticated clients by their API key header, then by X-Origin-System-Id, before their IP, for the rate limit",
+		EnvVar: "RATE_LIMIT_BY_HEADERS",
+	})
+	apiKeyHeader := app.String(cli.StringOpt{
+		Name:   "apiKeyHeader",
+		Value:  "X-Api-Key",
+		Desc:   "Header with the API key of the clients, checked against the API keys file and identifying them for the rate limit",
+		EnvVar: "API_KEY_HEADER",
+	})
+	apiKeysFile := app.String(cli.StringOpt{
+		Name:   "apiKeysFile",
+		Value:  "",
+		Desc:   "JSON file with the API keys allowed to call the unrolling endpoints, sent in the API key header",
+		EnvVar: "AUTH_API_KEYS_FILE",
+	})
+	jwksFile := app.String(cli.StringOpt{
+		Name:   "jwksFile",
+		Value:  "",
+		Desc:   "JWKS file with the RSA keys verifying the bearer tokens allowed to call the unrolling endpoints",
+		EnvVar: "AUTH_JWKS_FILE",
+	})
+	jwtIssuer := app.String(cli.StringOpt{
+		Name:   "jwtIssuer",
+		Value:  "",
+		Desc:   "Issuer required in the bearer tokens. Not checked when empty",
+		EnvVar: "AUTH_JWT_ISSUER",
+	})
+	jwtAudience := app.String(cli.StringOpt{
+		Name:   "jwtAudience",
+		Value:  "",
+		Desc:   "Audience required in the bearer tokens. Not checked when empty",
+		EnvVar: "AUTH_JWT_AUDIENCE",
+	})
+	jwtPermissionsClaim := app.String(cli.StringOpt{
+		Name:   "jwtPermissionsClaim",
+		Value:  "permissions",
+		Desc:   "Claim of the bearer tokens with the permissions of the caller",
+		EnvVar: "AUTH_JWT_PERMISSIONS_CLAIM",
+	})
+	clientFromOptions := func() *http.Client {
+		c, err := newHTTPClient(httpClientConfig{
+			timeout:               parseDuration("http client timeout", *httpClientTimeout),
+			dialTimeout:           parseDuration("dial timeout", *dialTimeout),
+			keepAlive:             parseDuration("keep-alive", *keepAlive),
+			tlsHandshakeTimeout:   parseDuration("TLS handshake timeout", *tlsHandshakeTimeout),
+			responseHeaderTimeout: parseDuration("response header timeout", *responseHeaderTimeout),
+			idleConnTimeout:       parseDuration("idle connection timeout", *idleConnTimeout),
+			maxIdleConns:          *maxIdleConns,
+			maxIdleConnsPerHost:   *maxIdleConnsPerHost,
+			maxConnsPerHost:       *maxConnsPerHost,
+			http2:                 *http2,
+			proxy:                 *httpProxy,
+		})
+		if err != nil {
+			log.Fatalf("Invalid HTTP client options: %v", err)
 		}
+		return c
+	}
+
+	app.Command("unroll", "Unroll an article file and print the result, without starting the server", func(cmd *cli.Cmd) {
+		input := cmd.String(cli.StringOpt{
+			Name: "input",
+			Desc: "JSON file with the article to unroll",
+		})
+		internal := cmd.Bool(cli.BoolOpt{
+			Name:  "internal",
+			Value: false,
+			Desc:  "Unroll the article like /internalcontent instead of /content",
+		})
+		sourceDir := cmd.String(cli.StringOpt{
+			Name: "source-dir",
+			Desc: "Directory with the JSON models to read instead of the content store, internal models in its internalcontent subdirectory",
+		})
+		replayDir := cmd.String(cli.StringOpt{
+			Name: "replay-dir",
+			Desc: "Directory with content store responses recorded with recordDir, replayed instead of calling the content store",
+		})
+		expand := cmd.String(cli.StringOpt{
+			Name: "expand",
+			Desc: "Optional expansions, e.g. related,brands,annotations",
+		})
+		cmd.Spec = "--input [--internal] [--source-dir | --replay-dir] [--expand]"
+
+		cmd.Action = func() {
+			setLogLevel(*logLevel)
+			httpClient := clientFromOptions()
+			contentClient := withTimeout(httpClient, "content read timeout", *contentReadTimeout)
+			internalClient := withTimeout(httpClient, "internal content read timeout", *internalContentReadTimeout)
+			var reader content.Reader
+			switch {
+			case *sourceDir != "":
+				fr, err := content.NewFileReader(*sourceDir)
+				if err != nil {
+					log.Fatalf("Invalid source directory: %v", err)
+				}
+				reader = fr
+			case *replayDir != "":
+				reader = content.NewReplayReader(content.ReaderConfig{
+					ContentStoreAppName:         *contentStoreApplicationName,
+					ContentPathEndpoint:         *contentPathEndpoint,
+					InternalContentPathEndpoint: *internalContentPathEndpoint,
+					RecordDir:                   *replayDir,
+				})
+			case *sourcesConfig != "":
+				reader, _ = setupRoutingReader(*sourcesConfig, contentClient, internalClient, "")
+			default:
+				reader = content.NewContentReader(content.ReaderConfig{
+					ContentStoreAppName:         *contentStoreApplicationName,
+					ContentStoreHost:            *contentStoreHost,
+					ContentPathEndpoint:         *contentPathEndpoint,
+					InternalContentPathEndpoint: *internalContentPathEndpoint,
+					InternalContentClient:       internalClient,
+				}, contentClient)
+			}
+
+			unrollerConfig := content.UnrollerConfig{
+				APIHost:      *apiHost,
+				APIScheme:    *apiScheme,
+				CanonicalIDs: *canonicalIDs,
+			}
+			if *thingsHost != "" {
+				unrollerConfig.ConceptReader = content.NewThingsReader(content.ThingsReaderConfig{
+					ThingsAppName:      *thingsApplicationName,
+					ThingsHost:         *thingsHost,
+					ThingsPathEndpoint: *thingsPathEndpoint,
+				}, httpClient)
+			}
+
+			err := unrollFile(content.NewContentUnroller(reader, unrollerConfig), *input, *internal, *expand, os.Stdout)
+			if err != nil {
+				log.Fatalf("Cannot unroll %s: %v", *input, err)
+			}
+		}
+	})
+
+	app.Action = func() {
+		setLogLevel(*logLevel)
+		httpClient := clientFromOptions()
+		contentClient := withTimeout(httpClient, "content read timeout", *contentReadTimeout)
+		internalClient := withTimeout(httpClient, "internal content read timeout", *internalContentReadTimeout)
 
 		sc := content.ServiceConfig{
 			ContentStoreAppName:      *contentStoreApplicationName,
@@ -84,37 +535,436 @@ func main() {
 			ContentStoreHost:            *contentStoreHost,
 			ContentPathEndpoint:         *contentPathEndpoint,
 			InternalContentPathEndpoint: *internalContentPathEndpoint,
+			InternalContentClient:       internalClient,
+			RecordDir:                   *recordDir,
+		}
+
+		var invalidators []content.Invalidator
+		var reader content.Reader
+		if *sourcesConfig != "" {
+			reader, sc.Sources = setupRoutingReader(*sourcesConfig, contentClient, internalClient, *recordDir)
+		} else {
+			contentReader := content.NewContentReader(readerConfig, contentClient)
+			expvar.Publish("contentReader", expvar.Func(func() interface{} { return contentReader.Metrics() }))
+			reader = contentReader
+		}
+		if *canaryUUID != "" {
+			sc.CanaryUUID = *canaryUUID
+			sc.CanaryReader = reader
+			sc.CanaryLatencyThreshold = parseDuration("canary latency threshold", *canaryLatencyThreshold)
+		}
+		modelTTL, err := time.ParseDuration(*contentCacheTTL)
+		if err != nil {
+			log.Fatalf("Invalid content cache TTL %s: %v", *contentCacheTTL, err)
+		}
+		if modelTTL > 0 {
+			cachingReader := content.NewCachingReader(reader, modelTTL, *contentCacheSize)
+			invalidators = append(invalidators, cachingReader)
+			reader = cachingReader
 		}
+		unrollerConfig := content.UnrollerConfig{
+			APIHost:      *apiHost,
+			APIScheme:    *apiScheme,
+			CanonicalIDs: *canonicalIDs,
+		}
+		if *thingsHost != "" {
+			thingsReader := content.NewThingsReader(content.ThingsReaderConfig{
+				ThingsAppName:      *thingsApplicationName,
+				ThingsHost:         *thingsHost,
+				ThingsPathEndpoint: *thingsPathEndpoint,
+			}, httpClient)
+			expvar.Publish("thingsReader", expvar.Func(func() interface{} { return thingsReader.Metrics() }))
+			unrollerConfig.ConceptReader = thingsReader
 
-		reader := content.NewContentReader(readerConfig, httpClient)
-		unroller := content.NewContentUnroller(reader, *apiHost)
+			conceptTTL, err := time.ParseDuration(*conceptCacheTTL)
+			if err != nil {
+				log.Fatalf("Invalid concept cache TTL %s: %v", *conceptCacheTTL, err)
+			}
+			if conceptTTL > 0 {
+				cachingConceptReader := content.NewCachingConceptReader(thingsReader, conceptTTL, *conceptCacheSize)
+				invalidators = append(invalidators, cachingConceptReader)
+				unrollerConfig.ConceptReader = cachingConceptReader
+			}
+		}
+		unroller := content.NewContentUnroller(reader, unrollerConfig)
 
-		h := setupServiceHandler(unroller, sc)
-		err := http.ListenAndServe(":"+*port, h)
+		cacheTTL, err := time.ParseDuration(*responseCacheTTL)
+		if err != nil {
+			log.Fatalf("Invalid response cache TTL %s: %v", *responseCacheTTL, err)
+		}
+		var cache *content.ResponseCache
+		if cacheTTL > 0 {
+			cache = content.NewResponseCache(cacheTTL, *responseCacheSize)
+			invalidators = append(invalidators, cache)
+		}
+
+		var workers []stopper
+		if *invalidationTopic != "" {
+			// every instance keeps its own caches, so each needs its own consumer group to see all notifications.
+			// The group has to keep its name across restarts, otherwise every restart leaves a group behind.
+			name := *instanceName
+			if name == "" {
+				name, _ = os.Hostname()
+			}
+			iw := &content.InvalidationWorker{
+				Invalidators: invalidators,
+				Consumer: content.NewKafkaProxyConsumer(content.KafkaProxyConfig{
+					Address:       *kafkaProxyAddress,
+					ConsumerGroup: *consumerGroup + "-invalidation-" + name,
+					Topic:         *invalidationTopic,
+					BackoffPeriod: 8 * time.Second,
+				}, httpClient),
+			}
+			go iw.Start()
+			workers = append(workers, iw)
+			log.Infof("Started evicting cached models on notifications from %s", *invalidationTopic)
+		}
+
+		if *workerMode {
+			worker := &content.UnrollWorker{
+				Service: unroller,
+				Consumer: content.NewKafkaProxyConsumer(content.KafkaProxyConfig{
+					Address:       *kafkaProxyAddress,
+					ConsumerGroup: *consumerGroup,
+					Topic:         *consumerTopic,
+					BackoffPeriod: 8 * time.Second,
+				}, httpClient),
+				Producer: content.NewKafkaProxyProducer(content.KafkaProxyConfig{
+					Address: *kafkaProxyAddress,
+					Topic:   *producerTopic,
+				}, httpClient),
+			}
+			if *deadLetterTopic != "" {
+				worker.DeadLetter = content.NewKafkaProxyProducer(content.KafkaProxyConfig{
+					Address: *kafkaProxyAddress,
+					Topic:   *deadLetterTopic,
+				}, httpClient)
+			}
+			go worker.Start()
+			workers = append(workers, worker)
+			log.Infof("Started worker consuming from %s and producing to %s", *consumerTopic, *producerTopic)
+		}
+
+		timeout, err := time.ParseDuration(*requestTimeout)
+		if err != nil {
+			log.Fatalf("Invalid request timeout %s: %v", *requestTimeout, err)
+		}
+
+		ch := &content.Handler{Service: unroller, Cache: cache, Timeout: timeout}
+
+		var ph *content.Handler
+		if *previewStoreHost != "" {
+			sc.PreviewStoreAppName = *previewStoreApplicationName
+			sc.PreviewStoreAppHealthURI = getServiceHealthURI(*previewStoreHost)
+			previewReader := content.NewContentReader(content.ReaderConfig{
+				ContentStoreAppName:         *previewStoreApplicationName,
+				ContentStoreHost:            *previewStoreHost,
+				ContentPathEndpoint:         *previewContentPathEndpoint,
+				InternalContentPathEndpoint: *previewInternalContentPathEndpoint,
+				InternalContentClient:       internalClient,
+			}, contentClient)
+			expvar.Publish("previewContentReader", expvar.Func(func() interface{} { return previewReader.Metrics() }))
+			// drafts change without notifications, so neither the models nor the responses are cached
+			ph = &content.Handler{Service: content.NewContentUnroller(previewReader, unrollerConfig), Timeout: timeout}
+		}
+
+		var cc *content.CheckCache
+		if interval := parseDuration("health check interval", *healthCheckInterval); interval > 0 {
+			cc = content.NewCheckCache(interval, 3*interval)
+		}
+		var rl *content.RateLimiter
+		rate, err := strconv.ParseFloat(*rateLimit, 64)
+		if err != nil {
+			log.Fatalf("Invalid rate limit %s: %v", *rateLimit, err)
+		}
+		if rate > 0 || *maxInFlight > 0 {
+			rl = content.NewRateLimiter(content.RateLimiterConfig{
+				Rate:          rate,
+				Burst:         *rateLimitBurst,
+				MaxInFlight:   *maxInFlight,
+				ClientHeaders: *rateLimitByHeaders,
+				APIKeyHeader:  *apiKeyHeader,
+			})
+			expvar.Publish("rateLimiter", expvar.Func(func() interface{} { return rl.Metrics() }))
+		}
+		auth := setupAuth(*apiKeysFile, *apiKeyHeader, content.JWTAuthenticatorConfig{
+			JWKSFile:         *jwksFile,
+			Issuer:           *jwtIssuer,
+			Audience:         *jwtAudience,
+			PermissionsClaim: *jwtPermissionsClaim,
+		})
+		if auth != nil {
+			auth.FailureLimiter = rl
+		}
+		h := setupServiceHandler(ch, ph, &sc, cc, rl, auth, invalidators)
+		if cc != nil {
+			go cc.Start()
+			workers = append(workers, cc)
+		}
+		srv := &http.Server{
+			Handler:      h,
+			ReadTimeout:  parseDuration("read timeout", *readTimeout),
+			WriteTimeout: parseDuration("write timeout", *writeTimeout),
+			IdleTimeout:  parseDuration("idle timeout", *idleTimeout),
+		}
+		l, err := net.Listen("tcp", ":"+*port)
 		if err != nil {
 			log.Fatalf("Unable to start server: %v", err)
 		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		err = serve(srv, l, &sc, workers, signals, parseDuration("shutdown delay", *shutdownDelay), parseDuration("shutdown grace period", *shutdownGracePeriod))
+		if err != nil {
+			log.Fatalf("Unable to shut down server gracefully: %v", err)
+		}
+		log.Info("Server stopped")
 	}
 
-	log.SetLevel(log.InfoLevel)
 	log.Infof("Application started with args %s", os.Args)
 	app.Run(os.Args)
 }
 
-func setupServiceHandler(s content.Unroller, sc content.ServiceConfig) *mux.Router {
+// stopper is a background worker. Stop blocks until the worker is stopped.
+type stopper interface {
+	Stop()
+}
+
+// serve serves requests until a signal is received. It then fails the gtg, keeps serving for the given delay so that
+// the load balancer stops sending requests, and waits for the in-flight requests and for the workers to stop within
+// the grace period.
+func serve(srv *http.Server, l net.Listener, sc *content.ServiceConfig, workers []stopper, signals <-chan os.Signal, delay time.Duration, grace time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		log.Infof("Received %v, shutting down", sig)
+	}
+
+	sc.StartDraining()
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	// the workers finish the messages they're handling alongside the in-flight requests
+	stopped := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, w := range workers {
+			wg.Add(1)
+			go func(w stopper) {
+				defer wg.Done()
+				w.Stop()
+			}(w)
+		}
+		wg.Wait()
+		close(stopped)
+	}()
+
+	err := srv.Shutdown(ctx)
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Warnf("Workers not stopped within the shutdown grace period of %v", grace)
+	}
+	return err
+}
+
+func parseDuration(name string, value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %s: %v", name, value, err)
+	}
+	return d
+}
+
+func setLogLevel(level string) {
+	l, err := log.ParseLevel(level)
+	if err != nil {
+		log.Fatalf("Invalid log level %s: %v", level, err)
+	}
+	content.SetLogLevel(l)
+}
+
+// proxyFromEnvironment as the proxy option reads the proxy from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables.
+const proxyFromEnvironment = "environment"
+
+type httpClientConfig struct {
+	timeout               time.Duration
+	dialTimeout           time.Duration
+	keepAlive             time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	maxConnsPerHost       int
+	http2                 bool
+	proxy                 string
+}
+
+func newHTTPClient(cfg httpClientConfig) (*http.Client, error) {
+	t := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.dialTimeout,
+			KeepAlive: cfg.keepAlive,
+		}).DialContext,
+		TLSHandshakeTimeout:   cfg.tlsHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.responseHeaderTimeout,
+		IdleConnTimeout:       cfg.idleConnTimeout,
+		MaxIdleConns:          cfg.maxIdleConns,
+		MaxIdleConnsPerHost:   cfg.maxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.maxConnsPerHost,
+		ForceAttemptHTTP2:     cfg.http2,
+	}
+
+	switch cfg.proxy {
+	case "":
+	case proxyFromEnvironment:
+		t.Proxy = http.ProxyFromEnvironment
+	default:
+		u, err := url.Parse(cfg.proxy)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("Invalid proxy URL %s", cfg.proxy)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Timeout: cfg.timeout, Transport: t}, nil
+}
+
+// withTimeout returns a client sharing the connections of c, with a different timeout when one is supplied.
+func withTimeout(c *http.Client, name string, timeout string) *http.Client {
+	if timeout == "" {
+		return c
+	}
+	return &http.Client{Transport: c.Transport, Timeout: parseDuration(name, timeout)}
+}
+
+// unrollFile unrolls the article read from path and writes it, indented, to w.
+// The unresolved references are only logged, so the output can be saved as a fixture.
+func unrollFile(u content.Unroller, path string, internal bool, expand string, w io.Writer) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tid := transactionidutils.NewTransactionID()
+	uc, unresolved, err := content.UnrollArticle(context.Background(), u, b, internal, expand, tid)
+	if err != nil {
+		return err
+	}
+	for _, ref := range unresolved {
+		log.Warnf("Unresolved reference %s: uuid=%s reason=%s", ref.Path, ref.UUID, ref.Reason)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(uc)
+}
+
+// setupAuth returns the authentication of the unrolling and admin endpoints, nil when neither API keys nor a JWKS are configured.
+func setupAuth(apiKeysFile string, apiKeyHeader string, jwtConfig content.JWTAuthenticatorConfig) *content.Auth {
+	var authenticators []content.Authenticator
+	if apiKeysFile != "" {
+		ka, err := content.NewAPIKeyAuthenticator(apiKeysFile, apiKeyHeader)
+		if err != nil {
+			log.Fatalf("Invalid API keys: %v", err)
+		}
+		authenticators = append(authenticators, ka)
+	}
+	if jwtConfig.JWKSFile != "" {
+		ja, err := content.NewJWTAuthenticator(jwtConfig)
+		if err != nil {
+			log.Fatalf("Invalid JWKS: %v", err)
+		}
+		authenticators = append(authenticators, ja)
+	}
+	if len(authenticators) == 0 {
+		log.Warn("No authentication configured, the unrolling and admin endpoints are open to any caller")
+		return nil
+	}
+	return &content.Auth{Authenticators: authenticators}
+}
+
+func setupRoutingReader(path string, client *http.Client, internalClient *http.Client, recordDir string) (*content.RoutingReader, []content.SourceConfig) {
+	sc, err := content.ReadSourcesConfig(path)
+	if err != nil {
+		log.Fatalf("Invalid content sources: %v", err)
+	}
+
+	var sources []content.Source
+	readers := make(map[string]*content.ContentReader)
+	for _, s := range sc.Sources {
+		rc := s.ReaderConfig()
+		rc.InternalContentClient = internalClient
+		rc.RecordDir = recordDir
+		r := content.NewContentReader(rc, client)
+		readers[s.Name] = r
+		sources = append(sources, content.Source{Name: s.Name, Reader: r, Types: s.Types})
+	}
+	expvar.Publish("contentSources", expvar.Func(func() interface{} {
+		metrics := make(map[string]content.ReaderMetrics)
+		for name, r := range readers {
+			metrics[name] = r.Metrics()
+		}
+		return metrics
+	}))
+
+	log.Infof("Reading content from %d sources", len(sources))
+	return content.NewRoutingReader(sources, sc.Routes), sc.Sources
+}
+
+// setupServiceHandler returns the router of the service. The healthchecks and the gtg are served from cc when not nil,
+// otherwise they call the backends on every request. The unrolling endpoints are limited by rl and restricted to the
+// callers authenticated by auth, when not nil. The authentication comes first, so that the rate limit applies to
+// the authenticated caller, the failed attempts being limited by auth itself.
+func setupServiceHandler(ch *content.Handler, ph *content.Handler, sc *content.ServiceConfig, cc *content.CheckCache, rl *content.RateLimiter, auth *content.Auth, invalidators []content.Invalidator) *mux.Router {
 	r := mux.NewRouter()
-	ch := &content.Handler{Service: s}
+	r.Use(content.AccessLog, auth.DebugLog)
+	// requests not matching any route skip the router middlewares
+	r.NotFoundHandler = content.AccessLog(http.NotFoundHandler())
+	ih := &content.InvalidationHandler{Invalidators: invalidators}
 
 	var checks []fthealth.Check
 	var gtgHandler func(http.ResponseWriter, *http.Request)
 
-	r.HandleFunc("/content", ch.GetContent).Methods("POST")
-	r.HandleFunc("/internalcontent", ch.GetInternalContent).Methods("POST")
-	checks = []fthealth.Check{sc.ContentStoreCheck()}
-	gtgHandler = httphandlers.NewGoodToGoHandler(gtg.StatusChecker(sc.GtgCheck))
+	r.Handle("/content", auth.Require(content.PermissionContent, rl.Limit(http.HandlerFunc(ch.GetContent)))).Methods("POST")
+	r.Handle("/internalcontent", auth.Require(content.PermissionInternalContent, rl.Limit(http.HandlerFunc(ch.GetInternalContent)))).Methods("POST")
+	r.Handle("/__invalidate", auth.Require(content.PermissionAdmin, http.HandlerFunc(ih.Invalidate))).Methods("POST")
+	checks = sc.ContentStoreChecks()
+	if sc.CanaryUUID != "" {
+		checks = append(checks, sc.ContentCanaryChecks()...)
+		checks = append(checks, sc.InternalContentCanaryChecks()...)
+	}
+	if ph != nil {
+		r.Handle("/contentpreview", auth.Require(content.PermissionContent, rl.Limit(http.HandlerFunc(ph.GetContent)))).Methods("POST")
+		r.Handle("/internalcontentpreview", auth.Require(content.PermissionInternalContent, rl.Limit(http.HandlerFunc(ph.GetInternalContent)))).Methods("POST")
+		checks = append(checks, sc.PreviewStoreCheck())
+	}
+	gtgCheck := gtg.StatusChecker(sc.GtgCheck)
+	if cc != nil {
+		for i, c := range checks {
+			checks[i] = cc.Check(c)
+		}
+		gtgCheck = sc.CachedGtgCheck(cc)
+	}
+	gtgHandler = httphandlers.NewGoodToGoHandler(gtgCheck)
 
 	r.Path(httphandlers.BuildInfoPath).HandlerFunc(httphandlers.BuildInfoHandler)
 	r.Path(httphandlers.PingPath).HandlerFunc(httphandlers.PingHandler)
+	r.Path("/__metrics").Handler(handlers.MethodHandler{"GET": auth.Require(content.PermissionAdmin, expvar.Handler())})
+	r.Path("/__log-level").Handler(handlers.MethodHandler{
+		"GET": http.HandlerFunc(content.GetLogLevelHandler),
+		"PUT": auth.Require(content.PermissionAdmin, http.HandlerFunc(content.SetLogLevelHandler)),
+	})
 
 	hc := fthealth.TimedHealthCheck{
 		HealthCheck: fthealth.HealthCheck{SystemCode: AppCode, Name: AppName, Description: AppDesc, Checks: checks},
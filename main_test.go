@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/content-unroller/content"
 	"github.com/gorilla/handlers"
@@ -94,6 +99,27 @@ func TestInternalContent_ShouldReturn200(t *testing.T) {
 	assert.JSONEq(t, string(expected), string(actualResponse))
 }
 
+func TestUnrollFile_UnrollsArticleWithLocalModels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-unroller")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	models, err := ioutil.ReadFile("test-resources/source-content-valid-response.json")
+	assert.NoError(t, err, "Cannot read file necessary for test case")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "models.json"), models, 0644))
+
+	reader, err := content.NewFileReader(dir)
+	assert.NoError(t, err)
+	unroller := content.NewContentUnroller(reader, content.UnrollerConfig{APIHost: "test.api.ft.com"})
+
+	var out bytes.Buffer
+	err = unrollFile(unroller, "test-resources/content-valid-request.json", false, "", &out)
+	assert.NoError(t, err)
+
+	expected, err := ioutil.ReadFile("test-resources/content-valid-response.json")
+	assert.NoError(t, err, "")
+	assert.JSONEq(t, string(expected), out.String())
+}
+
 func TestInternalContent_ShouldReturn400InvalidJson(t *testing.T) {
 	internalContentStoreServiceMock := startContentServerMock("test-resources/internalcontent-source-valid-response.json")
 	startUnrollerService(internalContentStoreServiceMock.URL)
@@ -190,6 +216,41 @@ func successfulContentServerMock(resource string) func(http.ResponseWriter, *htt
 	}
 }
 
+// startPreviewServerMock serves the published models with the drafts in the draft resources replacing them,
+// the way the preview store does.
+func startPreviewServerMock(t *testing.T, resource string, draftResources ...string) *httptest.Server {
+	b, err := ioutil.ReadFile(resource)
+	assert.NoError(t, err, "Cannot read file necessary for test case")
+	var models []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &models))
+	for _, dr := range draftResources {
+		b, err = ioutil.ReadFile(dr)
+		assert.NoError(t, err, "Cannot read file necessary for test case")
+		var draft map[string]interface{}
+		assert.NoError(t, json.Unmarshal(b, &draft))
+		replaced := false
+		for i, m := range models {
+			if m["id"] == draft["id"] {
+				models[i], replaced = draft, true
+			}
+		}
+		if !replaced {
+			models = append(models, draft)
+		}
+	}
+	body, err := json.Marshal(models)
+	assert.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.Path("/__health").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(statusOkHandler)})
+	router.Path("/__gtg").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(statusOkHandler)})
+	router.Path("/").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	})})
+
+	return httptest.NewServer(router)
+}
+
 func statusOkHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
@@ -206,6 +267,89 @@ func statusServiceUnavailableHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusServiceUnavailable)
 }
 
+func TestContentPreview_ShouldReturn200(t *testing.T) {
+	contentStoreServiceMock := startUnhealthyContentServerMock()
+	previewStoreServiceMock := startPreviewServerMock(t, "test-resources/source-content-valid-response.json", "test-resources/source-contentpreview-valid-response.json")
+	startUnrollerServiceWithPreview(contentStoreServiceMock.URL, previewStoreServiceMock.URL)
+	defer contentStoreServiceMock.Close()
+	defer previewStoreServiceMock.Close()
+	defer unrollerService.Close()
+
+	expected, err := ioutil.ReadFile("test-resources/contentpreview-valid-response.json")
+	assert.NoError(t, err, "")
+
+	body, err := ioutil.ReadFile("test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read file necessary for test case")
+	resp, err := http.Post(unrollerService.URL+"/contentpreview", "application/json", bytes.NewReader(body))
+	assert.NoError(t, err, "Should not fail")
+	defer resp.Body.Close()
+	actualResponse, err := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err, "")
+	assert.JSONEq(t, string(expected), string(actualResponse))
+}
+
+func TestInternalContentPreview_ShouldReturn200(t *testing.T) {
+	contentStoreServiceMock := startUnhealthyContentServerMock()
+	previewStoreServiceMock := startPreviewServerMock(t, "test-resources/internalcontent-source-valid-response.json", "test-resources/source-internalcontentpreview-valid-response.json")
+	startUnrollerServiceWithPreview(contentStoreServiceMock.URL, previewStoreServiceMock.URL)
+	defer contentStoreServiceMock.Close()
+	defer previewStoreServiceMock.Close()
+	defer unrollerService.Close()
+
+	expected, err := ioutil.ReadFile("test-resources/internalcontentpreview-valid-response-no-leadimages.json")
+	assert.NoError(t, err, "")
+
+	body, err := ioutil.ReadFile("test-resources/internalcontentpreview-valid-request.json")
+	assert.NoError(t, err, "Cannot read file necessary for test case")
+	resp, err := http.Post(unrollerService.URL+"/internalcontentpreview", "application/json", bytes.NewReader(body))
+	assert.NoError(t, err, "Should not fail")
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	actualResponse, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err, "")
+	assert.JSONEq(t, string(expected), string(actualResponse))
+}
+
+func TestContentPreview_ShouldReturn404WhenNotConfigured(t *testing.T) {
+	contentStoreServiceMock := startContentServerMock("test-resources/source-content-valid-response.json")
+	startUnrollerService(contentStoreServiceMock.URL)
+	defer contentStoreServiceMock.Close()
+	defer unrollerService.Close()
+
+	body, err := ioutil.ReadFile("test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read file necessary for test case")
+	resp, err := http.Post(unrollerService.URL+"/contentpreview", "application/json", bytes.NewReader(body))
+	assert.NoError(t, err, "Should not fail")
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func startUnrollerServiceWithPreview(contentStoreURL string, previewStoreURL string) {
+	sc := content.ServiceConfig{
+		ContentStoreAppName:      contentStoreAppName,
+		ContentStoreAppHealthURI: getServiceHealthURI(contentStoreURL),
+		PreviewStoreAppName:      "preview-source-app-name",
+		PreviewStoreAppHealthURI: getServiceHealthURI(previewStoreURL),
+		HTTPClient:               http.DefaultClient,
+	}
+
+	unroller := content.NewContentUnroller(content.NewContentReader(content.ReaderConfig{
+		ContentStoreAppName: contentStoreAppName,
+		ContentStoreHost:    contentStoreURL,
+	}, http.DefaultClient), content.UnrollerConfig{APIHost: "test.api.ft.com"})
+	previewUnroller := content.NewContentUnroller(content.NewContentReader(content.ReaderConfig{
+		ContentStoreAppName: "preview-source-app-name",
+		ContentStoreHost:    previewStoreURL,
+	}, http.DefaultClient), content.UnrollerConfig{APIHost: "test.api.ft.com"})
+
+	h := setupServiceHandler(&content.Handler{Service: unroller}, &content.Handler{Service: previewUnroller}, &sc, nil, nil, nil, nil)
+	unrollerService = httptest.NewServer(h)
+}
+
 func startUnrollerService(contentStoreURL string) {
 	sc := content.ServiceConfig{
 		ContentStoreAppName:      contentStoreAppName,
@@ -221,8 +365,155 @@ func startUnrollerService(contentStoreURL string) {
 	}
 
 	reader := content.NewContentReader(rc, http.DefaultClient)
-	unroller := content.NewContentUnroller(reader, "test.api.ft.com")
+	unroller := content.NewContentUnroller(reader, content.UnrollerConfig{APIHost: "test.api.ft.com"})
 
-	h := setupServiceHandler(unroller, sc)
+	h := setupServiceHandler(&content.Handler{Service: unroller}, nil, &sc, nil, nil, nil, nil)
 	unrollerService = httptest.NewServer(h)
 }
+
+func TestAdminEndpoints_RequireAdminPermission(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-unroller")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keys := `{"keys": [{"name": "next", "key": "next-secret", "permissions": ["content"]}, {"name": "ops", "key": "ops-secret", "permissions": ["admin"]}]}`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "keys.json"), []byte(keys), 0644))
+	auth := setupAuth(filepath.Join(dir, "keys.json"), "X-Api-Key", content.JWTAuthenticatorConfig{})
+
+	h := setupServiceHandler(&content.Handler{}, nil, &content.ServiceConfig{}, nil, nil, auth, nil)
+	call := func(method string, path string, body string, key string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Api-Key", key)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/__invalidate", `{"uuids": ["639cd952-149f-11e7-2ea7-a07ecd9ac73f"]}`, "next-secret"))
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/__invalidate", `{"uuids": ["639cd952-149f-11e7-2ea7-a07ecd9ac73f"]}`, "ops-secret"))
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/__metrics", "", "next-secret"))
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, "/__metrics", "", "ops-secret"))
+}
+
+type stopperMock struct {
+	stopped bool
+}
+
+func (s *stopperMock) Stop() {
+	s.stopped = true
+}
+
+func TestServe_DrainsInFlightRequestsOnSignal(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("unrolled"))
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	sc := &content.ServiceConfig{}
+	worker := &stopperMock{}
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(srv, l, sc, []stopper{worker}, signals, 50*time.Millisecond, 5*time.Second)
+	}()
+
+	type result struct {
+		status int
+		body   string
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/content")
+		if !assert.NoError(t, err) {
+			responses <- result{}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- result{resp.StatusCode, string(body)}
+	}()
+	<-started
+
+	signals <- syscall.SIGTERM
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, sc.GtgCheck().GoodToGo, "The gtg should fail as soon as the shutdown starts")
+
+	close(release)
+	res := <-responses
+	assert.Equal(t, http.StatusOK, res.status, "In-flight requests should be completed")
+	assert.Equal(t, "unrolled", res.body)
+	assert.NoError(t, <-served)
+	assert.True(t, worker.stopped)
+}
+
+type blockingStopper struct {
+	stopped chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStopper) Stop() {
+	close(s.stopped)
+	<-s.release
+}
+
+func TestServe_WaitsForWorkersWithinGracePeriod(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	quick := &blockingStopper{stopped: make(chan struct{}), release: make(chan struct{})}
+	close(quick.release)
+	hanging := &blockingStopper{stopped: make(chan struct{}), release: make(chan struct{})}
+	defer close(hanging.release)
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+
+	start := time.Now()
+	err = serve(&http.Server{Handler: http.NotFoundHandler()}, l, &content.ServiceConfig{}, []stopper{quick, hanging}, signals, 0, 100*time.Millisecond)
+	assert.NoError(t, err)
+	for _, w := range []*blockingStopper{quick, hanging} {
+		select {
+		case <-w.stopped:
+		default:
+			assert.Fail(t, "Every worker should be stopped")
+		}
+	}
+	assert.True(t, time.Since(start) < time.Second, "A worker not stopping shouldn't hold the shutdown beyond the grace period")
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	c, err := newHTTPClient(httpClientConfig{
+		timeout:             5 * time.Second,
+		maxIdleConnsPerHost: 10,
+		http2:               true,
+		proxy:               "http://proxy.ft.com:3128",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, c.Timeout)
+
+	tr := c.Transport.(*http.Transport)
+	assert.Equal(t, 10, tr.MaxIdleConnsPerHost)
+	assert.True(t, tr.ForceAttemptHTTP2)
+	req := httptest.NewRequest(http.MethodGet, "http://content-public-read/content", nil)
+	proxy, err := tr.Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "proxy.ft.com:3128", proxy.Host)
+
+	c, err = newHTTPClient(httpClientConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, c.Transport.(*http.Transport).Proxy, "No proxy should be used by default")
+
+	_, err = newHTTPClient(httpClientConfig{proxy: "proxy.ft.com"})
+	assert.Error(t, err)
+}
+
+func TestWithTimeout_SharesTransport(t *testing.T) {
+	c, err := newHTTPClient(httpClientConfig{timeout: 10 * time.Second})
+	assert.NoError(t, err)
+
+	assert.Equal(t, c, withTimeout(c, "content read timeout", ""))
+	internal := withTimeout(c, "internal content read timeout", "30s")
+	assert.Equal(t, 30*time.Second, internal.Timeout)
+	assert.Equal(t, c.Transport, internal.Transport)
+}